@@ -0,0 +1,283 @@
+// Package archive stores the message history of Discord channels in messages.db.
+package archive
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+type Client struct {
+	db   *sql.DB
+	lock sync.Mutex
+}
+
+func New(filename string) *Client {
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err = db.Exec(`PRAGMA journal_mode = 'WAL';`); err != nil {
+		log.Fatal(err)
+	}
+	if err := migrate(db); err != nil {
+		log.Fatal(err)
+	}
+	return &Client{
+		db: db,
+	}
+}
+
+// OpenReadOnly opens an archive for reading without migrating it. Archives
+// with an older schema are rejected, they have to be opened with New once.
+func OpenReadOnly(filename string) (*Client, error) {
+	if _, err := os.Stat(filename); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", "file:"+filename+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version;`).Scan(&version); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if version < schemaVersion {
+		db.Close()
+		return nil, fmt.Errorf("%s: archive schema version %d is older than %d, run the bot on it once to migrate it", filename, version, schemaVersion)
+	}
+	return &Client{
+		db: db,
+	}, nil
+}
+
+// schemaVersion is the user_version migrate brings a database to.
+const schemaVersion = 2
+
+// migrate brings the database up to the current schema, tracked in
+// PRAGMA user_version.
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version;`).Scan(&version); err != nil {
+		return err
+	}
+
+	if version < 1 {
+		// the first schema only kept id, timestamp, username and content
+		_, err := db.Exec(`CREATE TABLE IF NOT EXISTS"messages" ("id" TEXT, "timestamp" INTEGER, "autor" TEXT, "content" TEXT, PRIMARY KEY("id"));`)
+		if err != nil {
+			return err
+		}
+		if _, err := addColumn(db, "messages", "deleted", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+			return err
+		}
+		added, err := addColumn(db, "messages", "channel", `TEXT`)
+		if err != nil {
+			return err
+		}
+		if added {
+			// before the channel was stored, only this channel was archived
+			if _, err := db.Exec(`UPDATE messages SET channel = ? WHERE channel IS NULL;`, legacyChannel); err != nil {
+				return err
+			}
+		}
+		_, err = db.Exec(`CREATE TABLE IF NOT EXISTS "progress" ("channel" TEXT NOT NULL, "after" TEXT NOT NULL, PRIMARY KEY("channel"));`)
+		if err != nil {
+			return err
+		}
+		if added {
+			// the legacy channel continues at its newest archived message,
+			// before live messages can move it
+			_, err = db.Exec(`INSERT OR IGNORE INTO progress (channel, after)
+			SELECT channel, CAST(MAX(CAST(id AS INTEGER)) AS TEXT) FROM messages WHERE channel = ? GROUP BY channel;`, legacyChannel)
+			if err != nil {
+				return err
+			}
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		_, err = tx.Exec(`
+		CREATE TABLE "messages_v1" (
+			"id"	TEXT NOT NULL,
+			"guild"	TEXT,
+			"channel"	TEXT,
+			"parent"	TEXT,
+			"author_id"	TEXT,
+			"author"	TEXT,
+			"bot"	INTEGER,
+			"type"	INTEGER NOT NULL DEFAULT 0,
+			"timestamp"	INTEGER NOT NULL,
+			"edited"	INTEGER NOT NULL DEFAULT 0,
+			"content"	TEXT NOT NULL DEFAULT '',
+			"reference"	TEXT,
+			"deleted"	INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY("id")
+		);
+		INSERT INTO messages_v1 (id, channel, author, timestamp, content, deleted)
+			SELECT id, channel, autor, timestamp, content, deleted FROM messages;
+		DROP TABLE messages;
+		ALTER TABLE messages_v1 RENAME TO messages;
+		CREATE INDEX "messages_channel" ON "messages" ("channel", "timestamp");
+		CREATE INDEX "messages_reference" ON "messages" ("reference");
+		CREATE TABLE "edits" (
+			"message"	TEXT NOT NULL,
+			"timestamp"	INTEGER NOT NULL,
+			"content"	TEXT NOT NULL
+		);
+		CREATE INDEX "edits_message" ON "edits" ("message");
+		CREATE TABLE "attachments" (
+			"id"	TEXT NOT NULL,
+			"message"	TEXT NOT NULL,
+			"filename"	TEXT NOT NULL,
+			"content_type"	TEXT,
+			"size"	INTEGER,
+			"url"	TEXT,
+			PRIMARY KEY("id")
+		);
+		CREATE INDEX "attachments_message" ON "attachments" ("message");
+		PRAGMA user_version = 1;`)
+		if err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	if version < 2 {
+		// full-text index keyed by the numeric message ID, kept up to date by triggers
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		_, err = tx.Exec(`
+		CREATE VIRTUAL TABLE "messages_fts" USING fts5("content", tokenize = 'unicode61 remove_diacritics 2');
+		INSERT INTO messages_fts (rowid, content) SELECT CAST(id AS INTEGER), content FROM messages WHERE content != '';
+		CREATE TRIGGER "messages_fts_insert" AFTER INSERT ON "messages" BEGIN
+			INSERT INTO messages_fts (rowid, content) VALUES (CAST(new.id AS INTEGER), new.content);
+		END;
+		CREATE TRIGGER "messages_fts_update" AFTER UPDATE OF "content" ON "messages" BEGIN
+			DELETE FROM messages_fts WHERE rowid = CAST(old.id AS INTEGER);
+			INSERT INTO messages_fts (rowid, content) VALUES (CAST(new.id AS INTEGER), new.content);
+		END;
+		CREATE TRIGGER "messages_fts_delete" AFTER DELETE ON "messages" BEGIN
+			DELETE FROM messages_fts WHERE rowid = CAST(old.id AS INTEGER);
+		END;
+		PRAGMA user_version = 2;`)
+		if err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) Close() error {
+	return c.db.Close()
+}
+
+// MarkDeleted flags archived messages as deleted on Discord.
+func (c *Client) MarkDeleted(ids ...string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`UPDATE messages SET deleted = ? WHERE id = ?;`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	now := time.Now().UTC().Unix()
+	for _, id := range ids {
+		if _, err := stmt.Exec(now, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// addColumn adds a column to a table unless it already exists and reports
+// whether it was added.
+func addColumn(db *sql.DB, table, column, definition string) (bool, error) {
+	exists, err := hasColumn(db, table, column)
+	if err != nil || exists {
+		return false, err
+	}
+	_, err = db.Exec(`ALTER TABLE "` + table + `" ADD COLUMN "` + column + `" ` + definition + `;`)
+	return err == nil, err
+}
+
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?);`, table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	var name string
+	for rows.Next() {
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if strings.EqualFold(name, column) {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// Message is an archived message.
+type Message struct {
+	ID        string
+	Guild     string
+	Channel   string
+	AuthorID  string
+	Author    string
+	Bot       bool
+	Timestamp time.Time
+	Content   string
+	Reference string // message this one replies to
+}
+
+// Each calls fn for every archived message that was not deleted, ordered by
+// channel and time. Iteration stops at the first error returned by fn.
+func (c *Client) Each(fn func(m Message) error) error {
+	rows, err := c.db.Query(`SELECT id, COALESCE(guild, ''), COALESCE(channel, ''), COALESCE(author_id, ''), COALESCE(author, ''), COALESCE(bot, 0), timestamp, content, COALESCE(reference, '')
+	FROM messages WHERE deleted = 0 ORDER BY channel, timestamp, CAST(id AS INTEGER);`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			m         Message
+			timestamp int64
+		)
+		if err := rows.Scan(&m.ID, &m.Guild, &m.Channel, &m.AuthorID, &m.Author, &m.Bot, &timestamp, &m.Content, &m.Reference); err != nil {
+			return err
+		}
+		m.Timestamp = time.Unix(timestamp, 0)
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
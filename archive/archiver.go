@@ -0,0 +1,219 @@
+package archive
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+)
+
+// legacyChannel is the only channel archived before the channel of a message
+// was stored.
+const legacyChannel = "386904065558446081"
+
+// Save stores a single message. parent is the channel a thread belongs to,
+// empty for messages outside of threads. Edits of known messages keep their
+// previous content in the edit history.
+func (c *Client) Save(m *discordgo.Message, parent string) error {
+	return c.save([]*discordgo.Message{m}, parent, "", "")
+}
+
+// save stores messages and, if channel is set, moves the archive progress of
+// the channel to after in the same transaction.
+func (c *Client) save(messages []*discordgo.Message, parent, channel, after string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, m := range messages {
+		if m.Author == nil {
+			continue
+		}
+		if err := saveMessage(tx, m, parent); err != nil {
+			return err
+		}
+	}
+	if channel != "" {
+		_, err := tx.Exec(`INSERT OR REPLACE INTO progress (channel, after)VALUES(?,?);`, channel, after)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func saveMessage(tx *sql.Tx, m *discordgo.Message, parent string) error {
+	t, err := discordgo.SnowflakeTimestamp(m.ID)
+	if err != nil {
+		return err
+	}
+	var edited int64
+	if m.EditedTimestamp != nil {
+		edited = m.EditedTimestamp.UTC().Unix()
+	}
+	var reference sql.NullString
+	if m.MessageReference != nil && m.MessageReference.MessageID != "" {
+		reference = sql.NullString{String: m.MessageReference.MessageID, Valid: true}
+	}
+
+	var (
+		previous       string
+		previousEdited int64
+	)
+	err = tx.QueryRow(`SELECT content, edited FROM messages WHERE id = ?;`, m.ID).Scan(&previous, &previousEdited)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	case previous != m.Content:
+		if previousEdited == 0 {
+			previousEdited = t.UTC().Unix()
+		}
+		_, err := tx.Exec(`INSERT INTO edits (message, timestamp, content)VALUES(?,?,?);`, m.ID, previousEdited, previous)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO messages (id, guild, channel, parent, author_id, author, bot, type, timestamp, edited, content, reference)VALUES(?,?,?,?,?,?,?,?,?,?,?,?)
+	ON CONFLICT(id) DO UPDATE SET
+		guild = COALESCE(NULLIF(excluded.guild, ''), guild),
+		parent = COALESCE(NULLIF(excluded.parent, ''), parent),
+		author_id = excluded.author_id,
+		author = excluded.author,
+		bot = excluded.bot,
+		edited = excluded.edited,
+		content = excluded.content,
+		reference = COALESCE(excluded.reference, reference);`,
+		m.ID, m.GuildID, m.ChannelID, parent, m.Author.ID, m.Author.Username, m.Author.Bot, int(m.Type), t.UTC().Unix(), edited, m.Content, reference,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range m.Attachments {
+		_, err := tx.Exec(`INSERT OR REPLACE INTO attachments (id, message, filename, content_type, size, url)VALUES(?,?,?,?,?,?);`,
+			a.ID, m.ID, a.Filename, a.ContentType, a.Size, a.URL,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resume returns the message after which archiving of a channel continues.
+func (c *Client) resume(channel, after string) (string, error) {
+	var id string
+	err := c.db.QueryRow(`SELECT after FROM progress WHERE channel = ?;`, channel).Scan(&id)
+	switch err {
+	case nil:
+		return id, nil
+	case sql.ErrNoRows:
+	default:
+		return "", err
+	}
+
+	if after == "" {
+		after = "0"
+	}
+	return after, nil
+}
+
+// Backfill archives the history of channels, starting where the last
+// backfill stopped, or after the message after for new channels. It retries
+// with backoff on errors, skips channels the bot can't read and returns once
+// all channels are up to date or ctx is cancelled.
+func (c *Client) Backfill(ctx context.Context, s *discordgo.Session, channels []string, after string) {
+	for _, channel := range channels {
+		var guild, parent string
+		if ch, err := s.Channel(channel); err == nil {
+			guild = ch.GuildID
+			if ch.IsThread() {
+				parent = ch.ParentID
+			}
+		}
+
+		backoff := minBackoff
+		for ctx.Err() == nil {
+			done, err := c.backfillPage(s, guild, parent, channel, after)
+			if err == nil {
+				backoff = minBackoff
+				if done {
+					log.Println("[INFO]", "archive of channel", channel, "is up to date")
+					break
+				}
+				continue
+			}
+
+			// a missing or forbidden channel won't recover by retrying
+			var rerr *discordgo.RESTError
+			if errors.As(err, &rerr) && rerr.Response != nil && rerr.Response.StatusCode >= 400 &&
+				rerr.Response.StatusCode < 500 && rerr.Response.StatusCode != http.StatusTooManyRequests {
+				log.Println("[ERR]", "archiving channel", channel, err, "skipping it")
+				break
+			}
+
+			wait := backoff
+			var rl *discordgo.RateLimitError
+			if errors.As(err, &rl) && rl.RetryAfter > 0 {
+				wait = rl.RetryAfter
+			} else {
+				backoff *= 2
+				if backoff > maxBackoff {
+					backoff = maxBackoff
+				}
+			}
+			log.Println("[ERR]", "archiving channel", channel, err, "retrying in", wait)
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+		}
+	}
+}
+
+// backfillPage archives the next page of a channel and reports whether the
+// channel has no newer messages.
+func (c *Client) backfillPage(s *discordgo.Session, guild, parent, channel, after string) (bool, error) {
+	after, err := c.resume(channel, after)
+	if err != nil {
+		return false, err
+	}
+	page, err := s.ChannelMessages(channel, 100, "", after, "")
+	if err != nil {
+		return false, err
+	}
+	if len(page) == 0 {
+		return true, nil
+	}
+
+	newest, _ := strconv.ParseUint(after, 10, 64)
+	for _, m := range page {
+		if id, err := strconv.ParseUint(m.ID, 10, 64); err == nil && id > newest {
+			newest = id
+		}
+		if m.ChannelID == "" {
+			m.ChannelID = channel
+		}
+		if m.GuildID == "" {
+			m.GuildID = guild
+		}
+	}
+	return false, c.save(page, parent, channel, strconv.FormatUint(newest, 10))
+}
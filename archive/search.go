@@ -0,0 +1,114 @@
+package archive
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query describes a full-text search. Empty fields match everything.
+type Query struct {
+	Text     string
+	Guild    string
+	AuthorID string
+	Author   string // substring of the username
+	Channel  string
+	From     time.Time
+	To       time.Time
+	Deleted  bool // include deleted messages
+	Limit    int
+	Offset   int
+}
+
+type Result struct {
+	ID        string
+	Guild     string
+	Channel   string
+	AuthorID  string
+	Author    string
+	Timestamp time.Time
+	Content   string
+	Snippet   string // content around the matches, matches wrapped in **
+}
+
+// Search returns the best matching messages for q, newest first among equally
+// good matches.
+func (c *Client) Search(q Query) ([]Result, error) {
+	match := ftsQuery(q.Text)
+	if match == "" {
+		return []Result{}, nil
+	}
+
+	var (
+		where []string = []string{"messages_fts MATCH ?"}
+		args  []any    = []any{match}
+	)
+	if q.Guild != "" {
+		// messages archived before guilds were stored have none
+		where = append(where, "(m.guild = ? OR m.guild IS NULL)")
+		args = append(args, q.Guild)
+	}
+	if q.AuthorID != "" {
+		where = append(where, "m.author_id = ?")
+		args = append(args, q.AuthorID)
+	}
+	if q.Author != "" {
+		where = append(where, "m.author LIKE ?")
+		args = append(args, "%"+q.Author+"%")
+	}
+	if q.Channel != "" {
+		where = append(where, "(m.channel = ? OR m.parent = ?)")
+		args = append(args, q.Channel, q.Channel)
+	}
+	if !q.From.IsZero() {
+		where = append(where, "m.timestamp >= ?")
+		args = append(args, q.From.UTC().Unix())
+	}
+	if !q.To.IsZero() {
+		where = append(where, "m.timestamp < ?")
+		args = append(args, q.To.UTC().Unix())
+	}
+	if !q.Deleted {
+		where = append(where, "m.deleted = 0")
+	}
+	if q.Limit <= 0 {
+		q.Limit = 25
+	}
+	args = append(args, q.Limit, q.Offset)
+
+	rows, err := c.db.Query(`SELECT m.id, COALESCE(m.guild, ''), COALESCE(m.channel, ''), COALESCE(m.author_id, ''), COALESCE(m.author, ''), m.timestamp, m.content,
+		snippet(messages_fts, 0, '**', '**', '…', 24)
+	FROM messages_fts JOIN messages m ON m.id = CAST(messages_fts.rowid AS TEXT)
+	WHERE `+strings.Join(where, " AND ")+`
+	ORDER BY rank, m.timestamp DESC LIMIT ? OFFSET ?;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []Result = make([]Result, 0)
+	for rows.Next() {
+		var (
+			r         Result
+			timestamp int64
+		)
+		if err := rows.Scan(&r.ID, &r.Guild, &r.Channel, &r.AuthorID, &r.Author, &timestamp, &r.Content, &r.Snippet); err != nil {
+			return nil, err
+		}
+		r.Timestamp = time.Unix(timestamp, 0)
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// ftsQuery turns user input into an FTS5 query matching all of its words, so
+// quotes and operators typed by users cannot break the query.
+func ftsQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, w := range words {
+		words[i] = `"` + w + `"`
+	}
+	return strings.Join(words, " ")
+}
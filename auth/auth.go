@@ -0,0 +1,171 @@
+// Package auth keeps the accounts and login sessions of the web UI
+// moderators.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	_ "modernc.org/sqlite"
+)
+
+var (
+	ErrInvalidLogin = errors.New("invalid user name or password")
+	ErrNoSession    = errors.New("no valid session")
+)
+
+// SessionLifetime is how long a login stays valid.
+const SessionLifetime = 7 * 24 * time.Hour
+
+// Session is a logged in moderator.
+type Session struct {
+	Token   string
+	User    string
+	CSRF    string // token the forms of the session have to send back
+	Expires time.Time
+}
+
+// ValidCSRF reports whether a form token belongs to the session.
+func (s Session) ValidCSRF(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRF)) == 1
+}
+
+type Store struct {
+	db   *sql.DB
+	lock sync.Mutex
+}
+
+func New(filename string) *Store {
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(`
+	PRAGMA journal_mode = 'WAL';
+	BEGIN TRANSACTION;
+	CREATE TABLE IF NOT EXISTS "users" (
+		"name"	TEXT NOT NULL,
+		"password"	TEXT NOT NULL,
+		PRIMARY KEY("name")
+	);
+	CREATE TABLE IF NOT EXISTS "sessions" (
+		"token"	TEXT NOT NULL,
+		"user"	TEXT NOT NULL,
+		"csrf"	TEXT NOT NULL,
+		"expires"	INTEGER NOT NULL,
+		PRIMARY KEY("token")
+	);
+	COMMIT;`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &Store{
+		db: db,
+	}
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// SetUser creates a local account or changes its password.
+func (s *Store) SetUser(name, password string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.HasPrefix(name, "discord:") {
+		return errors.New("invalid user name")
+	}
+	if len(password) < 8 {
+		return errors.New("the password needs at least 8 characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err = s.db.Exec(`INSERT INTO users (name, password)VALUES(?,?)
+	ON CONFLICT (name) DO UPDATE SET password = excluded.password;`, name, string(hash))
+	return err
+}
+
+// Login checks the password of a local account and starts a session.
+func (s *Store) Login(name, password string) (Session, error) {
+	var hash string
+	err := s.db.QueryRow(`SELECT password FROM users WHERE name = ?;`, name).Scan(&hash)
+	if err == sql.ErrNoRows {
+		// compare anyway, so unknown users take as long as wrong passwords
+		bcrypt.CompareHashAndPassword([]byte("$2a$10$9HxWn/ofmh70sNg5r.eWOuLLDYpZ6CBBeHDDXid4MgOlRztp2eaQu"), []byte(password))
+		return Session{}, ErrInvalidLogin
+	} else if err != nil {
+		return Session{}, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return Session{}, ErrInvalidLogin
+	}
+	return s.Start(name)
+}
+
+// Start opens a session for a user that was authenticated elsewhere.
+func (s *Store) Start(user string) (Session, error) {
+	sess := Session{
+		Token:   Token(),
+		User:    user,
+		CSRF:    Token(),
+		Expires: time.Now().Add(SessionLifetime),
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.db.Exec(`DELETE FROM sessions WHERE expires < ?;`, time.Now().Unix()); err != nil {
+		return Session{}, err
+	}
+	_, err := s.db.Exec(`INSERT INTO sessions (token, user, csrf, expires)VALUES(?,?,?,?);`,
+		sess.Token, sess.User, sess.CSRF, sess.Expires.Unix())
+	return sess, err
+}
+
+// Session returns the session of a token.
+func (s *Store) Session(token string) (Session, error) {
+	sess := Session{Token: token}
+	var expires int64
+	err := s.db.QueryRow(`SELECT user, csrf, expires FROM sessions WHERE token = ?;`, token).Scan(&sess.User, &sess.CSRF, &expires)
+	if err == sql.ErrNoRows {
+		return sess, ErrNoSession
+	} else if err != nil {
+		return sess, err
+	}
+	sess.Expires = time.Unix(expires, 0)
+	if time.Now().After(sess.Expires) {
+		return sess, ErrNoSession
+	}
+	return sess, nil
+}
+
+// Logout ends a session.
+func (s *Store) Logout(token string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE token = ?;`, token)
+	return err
+}
+
+// Token returns a random URL safe token.
+func Token() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLogin(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "auth.db"))
+	defer s.Close()
+
+	if err := s.SetUser("alice", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Login("alice", "wrong horse"); err != ErrInvalidLogin {
+		t.Errorf("Login with wrong password = %v, want ErrInvalidLogin", err)
+	}
+	if _, err := s.Login("bob", "correct horse"); err != ErrInvalidLogin {
+		t.Errorf("Login of unknown user = %v, want ErrInvalidLogin", err)
+	}
+
+	sess, err := s.Login("alice", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Session(sess.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.User != "alice" || got.CSRF != sess.CSRF {
+		t.Errorf("Session() = %+v, want %+v", got, sess)
+	}
+
+	if err := s.Logout(sess.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Session(sess.Token); err != ErrNoSession {
+		t.Errorf("Session after Logout = %v, want ErrNoSession", err)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "auth.db"))
+	defer s.Close()
+
+	sess, err := s.Start("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := time.Now().Add(-time.Minute).Unix()
+	if _, err := s.db.Exec(`UPDATE sessions SET expires = ? WHERE token = ?;`, expired, sess.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Session(sess.Token); err != ErrNoSession {
+		t.Errorf("Session of expired token = %v, want ErrNoSession", err)
+	}
+
+	// starting a session clears the expired ones
+	if _, err := s.Start("bob"); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sessions WHERE token = ?;`, sess.Token).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Error("expired session was kept")
+	}
+}
+
+func TestValidCSRF(t *testing.T) {
+	sess := Session{CSRF: "token"}
+	for _, tt := range []struct {
+		token string
+		want  bool
+	}{
+		{"token", true},
+		{"other", false},
+		{"tok", false},
+		{"", false},
+	} {
+		if got := sess.ValidCSRF(tt.token); got != tt.want {
+			t.Errorf("ValidCSRF(%q) = %v, want %v", tt.token, got, tt.want)
+		}
+	}
+	if (Session{}).ValidCSRF("") {
+		t.Error("empty token is valid for a session without CSRF token")
+	}
+}
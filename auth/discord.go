@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OAuth signs moderators in with their Discord account. The endpoints default
+// to the ones of Discord and can point to a local stand-in for testing.
+type OAuth struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	AuthorizeURL string
+	TokenURL     string
+	UserURL      string
+
+	Client *http.Client // http.DefaultClient if nil
+}
+
+// DiscordUser is the account returned by the user endpoint.
+type DiscordUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+func (o OAuth) endpoints() (authorize, token, user string) {
+	authorize, token, user = o.AuthorizeURL, o.TokenURL, o.UserURL
+	if authorize == "" {
+		authorize = "https://discord.com/oauth2/authorize"
+	}
+	if token == "" {
+		token = "https://discord.com/api/oauth2/token"
+	}
+	if user == "" {
+		user = "https://discord.com/api/users/@me"
+	}
+	return
+}
+
+// AuthCodeURL returns the page that asks the user to sign in. The state is
+// sent back to the redirect URL.
+func (o OAuth) AuthCodeURL(state string) string {
+	authorize, _, _ := o.endpoints()
+	v := url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.ClientID},
+		"redirect_uri":  {o.RedirectURL},
+		"scope":         {"identify"},
+		"state":         {state},
+	}
+	if strings.Contains(authorize, "?") {
+		return authorize + "&" + v.Encode()
+	}
+	return authorize + "?" + v.Encode()
+}
+
+// Exchange trades the code of the redirect for an access token and returns the
+// account it belongs to.
+func (o OAuth) Exchange(ctx context.Context, code string) (DiscordUser, error) {
+	_, tokenURL, userURL := o.endpoints()
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {o.RedirectURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return DiscordUser{}, err
+	}
+	req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := do(client, req, &token); err != nil {
+		return DiscordUser{}, fmt.Errorf("token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return DiscordUser{}, fmt.Errorf("token: no access token in response")
+	}
+	if token.TokenType == "" {
+		token.TokenType = "Bearer"
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, userURL, nil)
+	if err != nil {
+		return DiscordUser{}, err
+	}
+	req.Header.Set("Authorization", token.TokenType+" "+token.AccessToken)
+	var user DiscordUser
+	if err := do(client, req, &user); err != nil {
+		return DiscordUser{}, fmt.Errorf("user: %w", err)
+	}
+	if user.ID == "" {
+		return DiscordUser{}, fmt.Errorf("user: no id in response")
+	}
+	return user, nil
+}
+
+func do(client *http.Client, req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
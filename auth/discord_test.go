@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// discord is a stand-in for the Discord OAuth endpoints.
+func discord(t *testing.T, tokenStatus int, user DiscordUser) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if r.Method != http.MethodPost || id != "client" || secret != "secret" {
+			http.Error(w, "bad client", http.StatusUnauthorized)
+			return
+		}
+		if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code") != "code" {
+			http.Error(w, "bad grant", http.StatusBadRequest)
+			return
+		}
+		if tokenStatus != http.StatusOK {
+			http.Error(w, "invalid_grant", tokenStatus)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "token_type": "Bearer"})
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(user)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func oauthFor(srv *httptest.Server) OAuth {
+	return OAuth{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/oauth/callback",
+		TokenURL:     srv.URL + "/token",
+		UserURL:      srv.URL + "/user",
+		Client:       srv.Client(),
+	}
+}
+
+func TestExchange(t *testing.T) {
+	srv := discord(t, http.StatusOK, DiscordUser{ID: "1234", Username: "alice"})
+	user, err := oauthFor(srv).Exchange(context.Background(), "code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != "1234" || user.Username != "alice" {
+		t.Errorf("Exchange() = %+v", user)
+	}
+}
+
+func TestExchangeTokenError(t *testing.T) {
+	srv := discord(t, http.StatusBadRequest, DiscordUser{ID: "1234"})
+	_, err := oauthFor(srv).Exchange(context.Background(), "code")
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("Exchange() error = %v, want the token error", err)
+	}
+}
+
+func TestExchangeMissingID(t *testing.T) {
+	srv := discord(t, http.StatusOK, DiscordUser{Username: "alice"})
+	_, err := oauthFor(srv).Exchange(context.Background(), "code")
+	if err == nil || !strings.Contains(err.Error(), "no id") {
+		t.Errorf("Exchange() error = %v, want a missing id error", err)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"dmpsupport/archive"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type command struct {
+	admin   bool
+	handler func(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, options map[string]*discordgo.ApplicationCommandInteractionDataOption)
+}
+
+var commands = []*discordgo.ApplicationCommand{
+	{
+		Name:        "ask",
+		Description: "Ask the support bot a question",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "question",
+				Description: "Your question",
+				Required:    true,
+			},
+		},
+	},
+	{
+		Name:        "learn",
+		Description: "Teach the bot a new reply (admin only)",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "trigger",
+				Description: "Message that triggers the reply",
+				Required:    true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "reply",
+				Description: "Reply the bot sends",
+				Required:    true,
+			},
+		},
+	},
+	{
+		Name:        "reload",
+		Description: "Reload the brain from disk (admin only)",
+	},
+	{
+		Name:        "persona",
+		Description: "Change the bot persona (admin only)",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "name",
+				Description: "Persona name",
+				Required:    true,
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "default", Value: "default"},
+					{Name: "tsundere", Value: "tsundere"},
+				},
+			},
+		},
+	},
+	{
+		Name:        "search",
+		Description: "Search the message archive of this channel",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "query",
+				Description: "Words to search for",
+				Required:    true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        "author",
+				Description: "Only messages of this user",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "since",
+				Description: "Only messages since this date (YYYY-MM-DD)",
+			},
+		},
+	},
+	{
+		Name:        "forget",
+		Description: "Forget everything the bot knows about you",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionUser,
+				Name:        "user",
+				Description: "User to forget (admin only)",
+			},
+		},
+	},
+}
+
+var commandHandlers = map[string]command{
+	"ask": {
+		handler: func(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, options map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+			err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+				Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			})
+			if err != nil {
+				log.Println("[ERR]", err)
+				return
+			}
+
+			question := options["question"].StringValue()
+			reply, err := rs.Reply(user.ID, question)
+			if err != nil {
+				log.Println("[ERR]", err, question)
+				reply = "Sorry, I don't know an answer to that. A human will have to help you."
+			}
+			content := fmt.Sprintf("> %s\n%s", question, reply)
+			if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+				Content: &content,
+			}); err != nil {
+				log.Println("[ERR]", err)
+			}
+		},
+	},
+	"learn": {
+		admin: true,
+		handler: func(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, options map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+			trigger := options["trigger"].StringValue()
+			reply := options["reply"].StringValue()
+			log.Println("learn new", user.Username, trigger, reply)
+			if err := rs.LearnNew(trigger, reply); err != nil {
+				log.Println("[ERR]", err)
+				respondEphemeral(s, i, "Could not learn that: "+err.Error())
+				return
+			}
+			respondEphemeral(s, i, fmt.Sprintf("Learned `%s`.", trigger))
+		},
+	},
+	"reload": {
+		admin: true,
+		handler: func(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, options map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+			if err := rs.Reload(); err != nil {
+				log.Println("[ERR]", err)
+				respondEphemeral(s, i, "Reload failed, the old brain is still active: "+err.Error())
+				return
+			}
+			respondEphemeral(s, i, "Brain reloaded.")
+		},
+	},
+	"persona": {
+		admin: true,
+		handler: func(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, options map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+			rs.SetPersona(options["name"].StringValue())
+			respondEphemeral(s, i, "Updated Bot persona to "+rs.Persona()+".")
+		},
+	},
+	"search": {
+		handler: func(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, options map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+			q := archive.Query{
+				Text:  options["query"].StringValue(),
+				Guild: i.GuildID,
+				Limit: 5,
+			}
+			// members may not be able to read every archived channel, so
+			// only admins search all of them
+			if !isAdmin(i.GuildID, user, i.Member) {
+				q.Channel = i.ChannelID
+			}
+			if o, ok := options["author"]; ok {
+				q.AuthorID = o.UserValue(nil).ID
+			}
+			if o, ok := options["since"]; ok {
+				t, err := time.Parse("2006-01-02", o.StringValue())
+				if err != nil {
+					respondEphemeral(s, i, "Dates look like 2023-01-31.")
+					return
+				}
+				q.From = t
+			}
+
+			results, err := messageArchive.Search(q)
+			if err != nil {
+				log.Println("[ERR]", err)
+				respondEphemeral(s, i, "Search failed.")
+				return
+			}
+			if len(results) == 0 {
+				respondEphemeral(s, i, "Nothing found.")
+				return
+			}
+			var b strings.Builder
+			for _, r := range results {
+				guild := r.Guild
+				if guild == "" {
+					guild = i.GuildID
+				}
+				snippet := strings.ReplaceAll(r.Snippet, "\n", " ")
+				fmt.Fprintf(&b, "**%s** <t:%d:d> %s\n> %s\n", r.Author, r.Timestamp.Unix(), messageLink(guild, r.Channel, r.ID), snippet)
+			}
+			content := b.String()
+			if len([]rune(content)) > 2000 {
+				content = string([]rune(content)[:1999]) + "…"
+			}
+			respondEphemeral(s, i, content)
+		},
+	},
+	"forget": {
+		handler: func(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, options map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+			target := user
+			if o, ok := options["user"]; ok {
+				target = o.UserValue(s)
+				if target.ID != user.ID && !isAdmin(i.GuildID, user, i.Member) {
+					respondEphemeral(s, i, "Only admins can make me forget other users.")
+					return
+				}
+			}
+			rs.Forget(target.ID)
+			respondEphemeral(s, i, "I forgot everything about <@"+target.ID+">.")
+		},
+	},
+}
+
+// registerCommands overwrites the application commands of every configured guild.
+func registerCommands(s *discordgo.Session) {
+	for _, g := range conf().Guilds {
+		if _, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, g.ID, commands); err != nil {
+			log.Println("[ERR]", "registering commands for guild", g.ID, err)
+		}
+	}
+}
+
+func onInteractionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand || conf().Guild(i.GuildID) == nil {
+		return
+	}
+
+	var user *discordgo.User
+	if i.Member != nil {
+		user = i.Member.User
+	} else {
+		user = i.User
+	}
+	if user == nil {
+		return
+	}
+
+	data := i.ApplicationCommandData()
+	c, ok := commandHandlers[data.Name]
+	if !ok {
+		return
+	}
+	if c.admin && !isAdmin(i.GuildID, user, i.Member) {
+		respondEphemeral(s, i, "This command is only available to admins.")
+		return
+	}
+
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(data.Options))
+	for _, o := range data.Options {
+		options[o.Name] = o
+	}
+	c.handler(s, i, user, options)
+}
+
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, content string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Println("[ERR]", err)
+	}
+}
@@ -0,0 +1,299 @@
+// Package config loads and validates the bot configuration file.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Duration is a time.Duration written as a string like "5s" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+type Config struct {
+	Token   string   `json:"token"`    // Discord bot token, the -token flag takes precedence
+	Listen  string   `json:"listen"`   // address of the web UI
+	DataDir string   `json:"data_dir"` // directory of the sqlite databases
+	Admins  []string `json:"admins"`   // users that are admin in every guild
+	Guilds  []Guild  `json:"guilds"`
+
+	Web       Web       `json:"web"`
+	Archive   Archive   `json:"archive"`
+	Typing    Typing    `json:"typing"`
+	Cooldowns Cooldowns `json:"cooldowns"`
+
+	guilds     map[string]*Guild
+	archived   map[string]bool
+	moderators map[string]bool
+}
+
+type Guild struct {
+	ID         string   `json:"id"`
+	Channels   []string `json:"channels"` // channels the bot answers in, empty for all
+	Mute       bool     `json:"mute"`     // mute replies in the whole guild
+	Muted      []string `json:"muted"`    // channels where replies are muted
+	Admins     []string `json:"admins"`
+	AdminRoles []string `json:"admin_roles"`
+	Forums     []string `json:"forums"`    // forum channels whose new posts are answered
+	ForumTag   string   `json:"forum_tag"` // tag applied to answered forum threads
+
+	Escalation Escalation `json:"escalation"`
+
+	channels   map[string]bool
+	muted      map[string]bool
+	admins     map[string]bool
+	adminRoles map[string]bool
+	forums     map[string]bool
+}
+
+// Escalation notifies helpers about questions that stay unanswered for Delay.
+type Escalation struct {
+	Delay        Duration `json:"delay"`         // zero disables escalation
+	HelperRole   string   `json:"helper_role"`   // role pinged in the question's channel
+	StaffChannel string   `json:"staff_channel"` // channel that receives a summary
+}
+
+// Web configures who may use the web UI. Local accounts are added with the
+// -adduser flag, Discord users sign in with OAuth if a client ID is set.
+type Web struct {
+	Moderators []string `json:"moderators"` // Discord users besides the admins
+	OAuth      OAuth    `json:"oauth"`
+}
+
+type OAuth struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RedirectURL  string `json:"redirect_url"`  // e.g. https://bot.example.com/oauth/callback
+	AuthorizeURL string `json:"authorize_url"` // defaults to the Discord endpoints
+	TokenURL     string `json:"token_url"`
+	UserURL      string `json:"user_url"`
+}
+
+type Archive struct {
+	Channels []string `json:"channels"`
+	After    string   `json:"after"` // oldest message to archive
+}
+
+type Typing struct {
+	Delay    Duration `json:"delay"`
+	PerChar  Duration `json:"per_char"`
+	Interval Duration `json:"interval"`
+}
+
+type Cooldowns struct {
+	User    Duration `json:"user"`
+	Channel Duration `json:"channel"`
+	Trigger Duration `json:"trigger"`
+}
+
+// Load reads and validates a configuration file.
+func Load(filename string) (*Config, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{
+		Listen:  ":21616",
+		DataDir: ".",
+		Typing: Typing{
+			Delay:    Duration(5 * time.Second),
+			PerChar:  Duration(150 * time.Millisecond),
+			Interval: Duration(time.Second),
+		},
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		var serr *json.SyntaxError
+		if errors.As(err, &serr) {
+			return nil, fmt.Errorf("%s: offset %d: %w", filename, serr.Offset, err)
+		}
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return c, nil
+}
+
+func (c *Config) validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("listen: %w", err))
+	}
+	if c.DataDir == "" {
+		c.DataDir = "."
+	}
+	if fi, err := os.Stat(c.DataDir); err != nil {
+		errs = append(errs, fmt.Errorf("data_dir: %w", err))
+	} else if !fi.IsDir() {
+		errs = append(errs, fmt.Errorf("data_dir: %s is not a directory", c.DataDir))
+	}
+	for i, id := range c.Admins {
+		errs = append(errs, snowflake(fmt.Sprintf("admins[%d]", i), id))
+	}
+	if len(c.Guilds) == 0 {
+		errs = append(errs, fmt.Errorf("guilds: at least one guild is required"))
+	}
+
+	c.guilds = make(map[string]*Guild, len(c.Guilds))
+	for i := range c.Guilds {
+		g := &c.Guilds[i]
+		name := fmt.Sprintf("guilds[%d]", i)
+		errs = append(errs, snowflake(name+".id", g.ID))
+		if _, ok := c.guilds[g.ID]; ok {
+			errs = append(errs, fmt.Errorf("%s.id: guild %s is configured twice", name, g.ID))
+		}
+		c.guilds[g.ID] = g
+
+		g.channels, errs = set(name+".channels", g.Channels, errs)
+		g.muted, errs = set(name+".muted", g.Muted, errs)
+		g.admins, errs = set(name+".admins", g.Admins, errs)
+		g.adminRoles, errs = set(name+".admin_roles", g.AdminRoles, errs)
+		g.forums, errs = set(name+".forums", g.Forums, errs)
+
+		if g.Escalation.Delay < 0 {
+			errs = append(errs, fmt.Errorf("%s.escalation.delay: must not be negative", name))
+		}
+		if g.Escalation.HelperRole != "" {
+			errs = append(errs, snowflake(name+".escalation.helper_role", g.Escalation.HelperRole))
+		}
+		if g.Escalation.StaffChannel != "" {
+			errs = append(errs, snowflake(name+".escalation.staff_channel", g.Escalation.StaffChannel))
+		}
+	}
+
+	c.moderators, errs = set("web.moderators", c.Web.Moderators, errs)
+	if o := c.Web.OAuth; o.ClientID != "" && (o.ClientSecret == "" || o.RedirectURL == "") {
+		errs = append(errs, fmt.Errorf("web.oauth: client_secret and redirect_url are required with a client_id"))
+	}
+
+	c.archived, errs = set("archive.channels", c.Archive.Channels, errs)
+	if c.Archive.After != "" {
+		errs = append(errs, snowflake("archive.after", c.Archive.After))
+	}
+
+	if c.Typing.Delay < 0 || c.Typing.PerChar < 0 || c.Typing.Interval < 0 {
+		errs = append(errs, fmt.Errorf("typing: durations must not be negative"))
+	}
+	if c.Cooldowns.User < 0 || c.Cooldowns.Channel < 0 || c.Cooldowns.Trigger < 0 {
+		errs = append(errs, fmt.Errorf("cooldowns: durations must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func set(name string, ids []string, errs []error) (map[string]bool, []error) {
+	m := make(map[string]bool, len(ids))
+	for i, id := range ids {
+		errs = append(errs, snowflake(fmt.Sprintf("%s[%d]", name, i), id))
+		m[id] = true
+	}
+	return m, errs
+}
+
+// snowflake checks that id looks like a Discord ID.
+func snowflake(name, id string) error {
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil || id == "" {
+		return fmt.Errorf("%s: %q is not a Discord ID", name, id)
+	}
+	return nil
+}
+
+// Guild returns the configuration of a guild, or nil if the bot should ignore it.
+func (c *Config) Guild(id string) *Guild {
+	return c.guilds[id]
+}
+
+// Allowed reports whether the bot answers in a channel. For threads, the
+// parent channel is checked as well, and threads in support forums are always
+// allowed.
+func (c *Config) Allowed(guild, channel, parent string) bool {
+	g := c.Guild(guild)
+	if g == nil {
+		return false
+	}
+	return len(g.channels) == 0 || g.channels[channel] || (parent != "" && (g.channels[parent] || g.forums[parent]))
+}
+
+// Muted reports whether replies in a channel are muted.
+func (c *Config) Muted(guild, channel, parent string) bool {
+	g := c.Guild(guild)
+	if g == nil {
+		return true
+	}
+	return g.Mute || g.muted[channel] || (parent != "" && g.muted[parent])
+}
+
+// IsAdmin reports whether a member with the given roles is an admin of a guild.
+func (c *Config) IsAdmin(guild, user string, roles []string) bool {
+	for _, id := range c.Admins {
+		if id == user {
+			return true
+		}
+	}
+	g := c.Guild(guild)
+	if g == nil {
+		return false
+	}
+	if g.admins[user] {
+		return true
+	}
+	for _, r := range roles {
+		if g.adminRoles[r] {
+			return true
+		}
+	}
+	return false
+}
+
+// IsModerator reports whether a Discord user may sign in to the web UI. Admins
+// of any guild are moderators.
+func (c *Config) IsModerator(user string) bool {
+	if c.moderators[user] {
+		return true
+	}
+	for _, id := range c.Admins {
+		if id == user {
+			return true
+		}
+	}
+	for _, g := range c.guilds {
+		if g.admins[user] {
+			return true
+		}
+	}
+	return false
+}
+
+// Archived reports whether the messages of a channel are archived.
+func (c *Config) Archived(channel string) bool {
+	return c.archived[channel]
+}
+
+// IsForum reports whether a channel is a support forum of a guild.
+func (c *Config) IsForum(guild, channel string) bool {
+	g := c.Guild(guild)
+	return g != nil && g.forums[channel]
+}
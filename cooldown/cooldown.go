@@ -0,0 +1,110 @@
+// Package cooldown limits how often the bot replies to the same user, in the
+// same channel or with the same trigger.
+package cooldown
+
+import (
+	"sync"
+	"time"
+)
+
+// Limits are the minimum durations between two replies. A zero duration
+// disables the limit.
+type Limits struct {
+	User    time.Duration
+	Channel time.Duration
+	Trigger time.Duration // per trigger and channel
+}
+
+type Limiter struct {
+	limits Limits
+
+	lock sync.Mutex
+	last map[string]time.Time
+}
+
+func New(limits Limits) *Limiter {
+	return &Limiter{
+		limits: limits,
+		last:   make(map[string]time.Time),
+	}
+}
+
+// SetLimits replaces the limits of the limiter.
+func (l *Limiter) SetLimits(limits Limits) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.limits = limits
+}
+
+// Check reports whether a reply may be sent now without counting it against
+// the limits. Otherwise the name of the limit that blocked the reply is
+// returned.
+func (l *Limiter) Check(user, channel, trigger string) (bool, string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.check(time.Now(), user, channel, trigger)
+}
+
+// Allow reports whether a reply may be sent now. If it may, the reply is
+// counted against all limits. Otherwise the name of the limit that blocked
+// the reply is returned.
+func (l *Limiter) Allow(user, channel, trigger string) (bool, string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	if ok, name := l.check(now, user, channel, trigger); !ok {
+		return false, name
+	}
+	for _, k := range l.keys(user, channel, trigger) {
+		if k.d > 0 {
+			l.last[k.key] = now
+		}
+	}
+	l.prune(now)
+	return true, ""
+}
+
+type limit struct {
+	name string
+	key  string
+	d    time.Duration
+}
+
+func (l *Limiter) keys(user, channel, trigger string) []limit {
+	return []limit{
+		{"user", "user:" + user, l.limits.User},
+		{"channel", "channel:" + channel, l.limits.Channel},
+		{"trigger", "trigger:" + channel + ":" + trigger, l.limits.Trigger},
+	}
+}
+
+func (l *Limiter) check(now time.Time, user, channel, trigger string) (bool, string) {
+	for _, k := range l.keys(user, channel, trigger) {
+		if k.d > 0 && now.Sub(l.last[k.key]) < k.d {
+			return false, k.name
+		}
+	}
+	return true, ""
+}
+
+// prune drops entries that can no longer block a reply.
+func (l *Limiter) prune(now time.Time) {
+	if len(l.last) < 1000 {
+		return
+	}
+	max := l.limits.User
+	if l.limits.Channel > max {
+		max = l.limits.Channel
+	}
+	if l.limits.Trigger > max {
+		max = l.limits.Trigger
+	}
+	for k, t := range l.last {
+		if now.Sub(t) >= max {
+			delete(l.last, k)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+type escalation struct {
+	m     *discordgo.Message
+	text  string
+	timer *time.Timer
+}
+
+// Escalator notifies helpers about questions nobody answered in time.
+type Escalator struct {
+	s *discordgo.Session
+
+	lock    sync.Mutex
+	pending map[string]*escalation
+}
+
+func NewEscalator(s *discordgo.Session) *Escalator {
+	return &Escalator{
+		s:       s,
+		pending: make(map[string]*escalation),
+	}
+}
+
+// Schedule escalates a question after the delay configured for its guild,
+// unless it is answered before.
+func (e *Escalator) Schedule(m *discordgo.Message, text string) {
+	g := conf().Guild(m.GuildID)
+	if g == nil || g.Escalation.Delay <= 0 || (g.Escalation.HelperRole == "" && g.Escalation.StaffChannel == "") {
+		return
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if p, ok := e.pending[m.ID]; ok {
+		p.timer.Stop()
+	}
+	p := &escalation{m: m, text: text}
+	p.timer = time.AfterFunc(time.Duration(g.Escalation.Delay), func() {
+		e.fire(p)
+	})
+	e.pending[m.ID] = p
+}
+
+// Cancel drops the escalation of a question.
+func (e *Escalator) Cancel(id string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if p, ok := e.pending[id]; ok {
+		p.timer.Stop()
+		delete(e.pending, id)
+	}
+}
+
+// Answered cancels the escalations a new message answers. Any message of
+// another user in the channel counts as an answer, the bot only answers a
+// question by replying to it.
+func (e *Escalator) Answered(m *discordgo.Message) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for id, p := range e.pending {
+		if p.m.ChannelID != m.ChannelID || p.m.Author.ID == m.Author.ID {
+			continue
+		}
+		if m.Author.ID == e.s.State.User.ID && (m.MessageReference == nil || m.MessageReference.MessageID != id) {
+			continue
+		}
+		p.timer.Stop()
+		delete(e.pending, id)
+	}
+}
+
+func (e *Escalator) fire(p *escalation) {
+	e.lock.Lock()
+	if e.pending[p.m.ID] != p {
+		e.lock.Unlock()
+		return
+	}
+	delete(e.pending, p.m.ID)
+	e.lock.Unlock()
+
+	// messages we missed while disconnected may have answered the question
+	after, err := e.s.ChannelMessages(p.m.ChannelID, 100, "", p.m.ID, "")
+	if err != nil {
+		log.Println("[ERR]", err)
+	}
+	for _, m := range after {
+		if m.Author != nil && m.Author.ID != p.m.Author.ID && m.Author.ID != e.s.State.User.ID {
+			return
+		}
+	}
+
+	g := conf().Guild(p.m.GuildID)
+	if g == nil {
+		return
+	}
+	if g.Escalation.HelperRole != "" {
+		_, err := e.s.ChannelMessageSendComplex(p.m.ChannelID, &discordgo.MessageSend{
+			Content:         fmt.Sprintf("<@&%s> this question is still waiting for an answer.", g.Escalation.HelperRole),
+			Reference:       p.m.Reference(),
+			AllowedMentions: &discordgo.MessageAllowedMentions{Roles: []string{g.Escalation.HelperRole}},
+		})
+		if err != nil {
+			log.Println("[ERR]", err)
+		}
+	}
+	if g.Escalation.StaffChannel != "" {
+		text := p.text
+		if len([]rune(text)) > 1000 {
+			text = string([]rune(text)[:1000]) + "…"
+		}
+		_, err := e.s.ChannelMessageSendEmbed(g.Escalation.StaffChannel, &discordgo.MessageEmbed{
+			Title:       "Unanswered question",
+			URL:         messageLink(p.m.GuildID, p.m.ChannelID, p.m.ID),
+			Description: strings.TrimSpace(text),
+			Author:      &discordgo.MessageEmbedAuthor{Name: p.m.Author.Username},
+			Fields: []*discordgo.MessageEmbedField{
+				{Name: "Channel", Value: "<#" + p.m.ChannelID + ">", Inline: true},
+				{Name: "Waiting", Value: time.Duration(g.Escalation.Delay).String(), Inline: true},
+			},
+		})
+		if err != nil {
+			log.Println("[ERR]", err)
+		}
+	}
+}
+
+// messageLink returns the jump link of a Discord message.
+func messageLink(guild, channel, id string) string {
+	return "https://discord.com/channels/" + guild + "/" + channel + "/" + id
+}
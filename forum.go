@@ -0,0 +1,137 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// onThreadCreate answers the opening post of a new thread in a support forum.
+func onThreadCreate(responder *Responder) func(s *discordgo.Session, t *discordgo.ThreadCreate) {
+	return func(s *discordgo.Session, t *discordgo.ThreadCreate) {
+		if !t.NewlyCreated || !conf().IsForum(t.GuildID, t.ParentID) {
+			return
+		}
+
+		// The opening post has the same ID as the thread, but it may not be
+		// available yet when the thread is announced.
+		var (
+			m   *discordgo.Message
+			err error
+		)
+		for i := 0; i < 3; i++ {
+			if m, err = s.ChannelMessage(t.ID, t.ID); err == nil {
+				break
+			}
+			time.Sleep(time.Second)
+		}
+		if err != nil {
+			log.Println("[ERR]", err)
+			return
+		}
+		if m.Author == nil || m.Author.ID == s.State.User.ID {
+			return
+		}
+
+		responder.Respond(openingPost(t.Channel, m))
+	}
+}
+
+// openingPost returns the opening post of a forum thread the way the bot
+// matches it: the thread title followed by the message.
+func openingPost(thread *discordgo.Channel, m *discordgo.Message) *discordgo.Message {
+	post := *m
+	post.GuildID = thread.GuildID
+	post.Content = strings.TrimSpace(thread.Name + "\n" + m.Content)
+	return &post
+}
+
+// forumPost returns m with the thread title added if it is the opening post of
+// a thread in a support forum, and m itself otherwise.
+func forumPost(s *discordgo.Session, m *discordgo.Message) *discordgo.Message {
+	if m.ID != m.ChannelID || forumThread(s, m.ChannelID) == "" {
+		return m
+	}
+	thread, err := s.State.Channel(m.ChannelID)
+	if err != nil {
+		if thread, err = s.Channel(m.ChannelID); err != nil {
+			return m
+		}
+	}
+	return openingPost(thread, m)
+}
+
+// forumThread returns the forum a channel belongs to, or "" if the channel is
+// not a thread in one of the configured forums.
+func forumThread(s *discordgo.Session, channelID string) string {
+	c, err := s.State.Channel(channelID)
+	if err != nil {
+		if c, err = s.Channel(channelID); err != nil {
+			return ""
+		}
+	}
+	if !c.IsThread() || !conf().IsForum(c.GuildID, c.ParentID) {
+		return ""
+	}
+	return c.ParentID
+}
+
+// sessionName returns the RiveScript user of a message. Conversations in forum
+// threads get their own session per thread.
+func sessionName(s *discordgo.Session, m *discordgo.Message) string {
+	if forumThread(s, m.ChannelID) != "" {
+		return m.Author.ID + ":" + m.ChannelID
+	}
+	return m.Author.ID
+}
+
+// tagAnswered applies the configured forum tag to a thread.
+func tagAnswered(s *discordgo.Session, threadID string) {
+	parent := forumThread(s, threadID)
+	if parent == "" {
+		return
+	}
+
+	forum, err := s.Channel(parent)
+	if err != nil {
+		log.Println("[ERR]", err)
+		return
+	}
+	g := conf().Guild(forum.GuildID)
+	if g == nil || g.ForumTag == "" {
+		return
+	}
+	forumTag := g.ForumTag
+	var tag string
+	for _, t := range forum.AvailableTags {
+		if strings.EqualFold(t.Name, forumTag) {
+			tag = t.ID
+			break
+		}
+	}
+	if tag == "" {
+		log.Println("[ERR]", "forum", parent, "has no tag", forumTag)
+		return
+	}
+
+	thread, err := s.Channel(threadID)
+	if err != nil {
+		log.Println("[ERR]", err)
+		return
+	}
+	tags := thread.AppliedTags
+	for _, t := range tags {
+		if t == tag {
+			return
+		}
+	}
+	if len(tags) >= 5 {
+		return
+	}
+	tags = append(tags, tag)
+	if _, err := s.ChannelEditComplex(threadID, &discordgo.ChannelEdit{AppliedTags: &tags}); err != nil {
+		log.Println("[ERR]", err)
+	}
+}
@@ -6,6 +6,7 @@ require (
 	github.com/aichaos/rivescript-go v0.3.1
 	github.com/bwmarrin/discordgo v0.27.0
 	github.com/robertkrimen/otto v0.2.1
+	golang.org/x/crypto v0.6.0
 	modernc.org/sqlite v1.20.4
 )
 
@@ -16,7 +17,6 @@ require (
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
-	golang.org/x/crypto v0.6.0 // indirect
 	golang.org/x/mod v0.8.0 // indirect
 	golang.org/x/sys v0.5.0 // indirect
 	golang.org/x/text v0.7.0 // indirect
@@ -1,8 +0,0 @@
-package helpers
-
-func ReverseSlice[S any](s []S) []S {
-	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
-		s[i], s[j] = s[j], s[i]
-	}
-	return s
-}
@@ -0,0 +1,132 @@
+// Package ingest extracts error messages from log files and paste links that
+// users post instead of typing out the error.
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// MaxSize is the largest file or paste that is downloaded.
+const MaxSize = 1 << 20
+
+// MaxLines is the number of error lines passed on from a single message.
+const MaxLines = 10
+
+var textExtensions = map[string]bool{
+	".log": true,
+	".txt": true,
+}
+
+// pastes maps links of known paste sites to the URL of their raw content.
+var pastes = []struct {
+	re  *regexp.Regexp
+	raw string
+}{
+	{regexp.MustCompile(`https?://pastebin\.com/(?:raw/)?([A-Za-z0-9]+)`), "https://pastebin.com/raw/%s"},
+	{regexp.MustCompile(`https?://(?:www\.)?hastebin\.com/(?:raw/|share/)?([A-Za-z0-9]+)(?:\.[a-z]+)?`), "https://hastebin.com/raw/%s"},
+	{regexp.MustCompile(`https?://paste\.ee/[pr]/([A-Za-z0-9]+)`), "https://paste.ee/r/%s"},
+	{regexp.MustCompile(`https?://pastes\.dev/([A-Za-z0-9]+)`), "https://api.pastes.dev/%s"},
+}
+
+var errorLine = regexp.MustCompile(`(?i)error|exception|fail|refused|timed out|timeout|unable to|could not`)
+
+// logPrefix matches timestamps and levels at the start of log lines, like
+// "[12:34:56] [Error]: " or "[LOG 12:34:56.789] ".
+var logPrefix = regexp.MustCompile(`^(?:\s*\[[^\]]*\]\s*:?)+`)
+
+type Client struct {
+	http *http.Client
+}
+
+func New() *Client {
+	return &Client{
+		http: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Extract downloads the text attachments and paste links of a message and
+// returns the error lines found in them.
+func (c *Client) Extract(ctx context.Context, m *discordgo.Message) string {
+	var urls []string
+	for _, a := range m.Attachments {
+		if a.Size > MaxSize {
+			continue
+		}
+		if textExtensions[strings.ToLower(path.Ext(a.Filename))] || strings.HasPrefix(a.ContentType, "text/") {
+			urls = append(urls, a.URL)
+		}
+	}
+	for _, p := range pastes {
+		for _, match := range p.re.FindAllStringSubmatch(m.Content, -1) {
+			urls = append(urls, fmt.Sprintf(p.raw, match[1]))
+		}
+	}
+
+	var (
+		lines []string
+		seen  map[string]bool = make(map[string]bool)
+	)
+	for _, u := range urls {
+		body, err := c.get(ctx, u)
+		if err != nil {
+			continue
+		}
+		for _, l := range ErrorLines(body) {
+			if !seen[l] && len(lines) < MaxLines {
+				seen[l] = true
+				lines = append(lines, l)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c *Client) get(ctx context.Context, u string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", u, res.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, MaxSize))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// ErrorLines returns the lines of a log that look like errors, without their
+// timestamp and level prefix.
+func ErrorLines(text string) []string {
+	var lines []string
+	sc := bufio.NewScanner(strings.NewReader(text))
+	sc.Buffer(make([]byte, 64*1024), MaxSize)
+	for sc.Scan() {
+		l := sc.Text()
+		if !errorLine.MatchString(l) {
+			continue
+		}
+		l = strings.TrimSpace(logPrefix.ReplaceAllString(l, ""))
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
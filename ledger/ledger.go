@@ -0,0 +1,173 @@
+// Package ledger keeps a record of every reply the bot sent or held back.
+package ledger
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+type Status string
+
+const (
+	StatusSent   Status = "sent"
+	StatusEdited Status = "edited"
+	StatusMuted  Status = "muted"
+
+	StatusCooldown Status = "cooldown"
+)
+
+type Entry struct {
+	ID        int64
+	Timestamp time.Time
+	Source    string // message that triggered the reply
+	Guild     string
+	Channel   string
+	Reply     string // message the bot sent, empty if nothing was sent
+	Trigger   string
+	Topic     string
+	Persona   string
+	Text      string
+	Latency   time.Duration // time between the source message and the reply
+	Status    Status
+	Moderator string // web UI user that sent the reply, empty for the bot
+}
+
+// Filter narrows down the entries returned by List. Empty fields match
+// everything.
+type Filter struct {
+	Guild   string
+	Channel string
+	Status  Status
+	Search  string // substring of the reply text or trigger
+	Limit   int
+	Offset  int
+}
+
+type Client struct {
+	db   *sql.DB
+	lock sync.Mutex
+}
+
+func New(filename string) *Client {
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(`
+	PRAGMA journal_mode = 'WAL';
+	BEGIN TRANSACTION;
+	CREATE TABLE IF NOT EXISTS "ledger" (
+		"id"	INTEGER,
+		"timestamp"	INTEGER NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+		"source"	TEXT NOT NULL,
+		"guild"	TEXT NOT NULL,
+		"channel"	TEXT NOT NULL,
+		"reply"	TEXT NOT NULL,
+		"trigger"	TEXT NOT NULL,
+		"topic"	TEXT NOT NULL,
+		"persona"	TEXT NOT NULL,
+		"text"	TEXT NOT NULL,
+		"latency"	INTEGER NOT NULL,
+		"status"	TEXT NOT NULL,
+		PRIMARY KEY("id" AUTOINCREMENT)
+	);
+	CREATE INDEX IF NOT EXISTS "ledger_source" ON "ledger" ("source");
+	COMMIT;`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var moderator int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('ledger') WHERE name = 'moderator';`).Scan(&moderator); err != nil {
+		log.Fatal(err)
+	}
+	if moderator == 0 {
+		if _, err := db.Exec(`ALTER TABLE "ledger" ADD COLUMN "moderator" TEXT NOT NULL DEFAULT '';`); err != nil {
+			log.Fatal(err)
+		}
+	}
+	return &Client{
+		db: db,
+	}
+}
+
+func (c *Client) Close() error {
+	return c.db.Close()
+}
+
+// Record adds an entry to the ledger.
+func (c *Client) Record(e Entry) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	_, err := c.db.Exec(`INSERT INTO ledger (timestamp, source, guild, channel, reply, trigger, topic, persona, text, latency, status, moderator)VALUES(?,?,?,?,?,?,?,?,?,?,?,?);`,
+		e.Timestamp.UTC().Unix(), e.Source, e.Guild, e.Channel, e.Reply, e.Trigger, e.Topic, e.Persona, e.Text, e.Latency.Milliseconds(), string(e.Status), e.Moderator,
+	)
+	return err
+}
+
+// List returns the newest entries matching f.
+func (c *Client) List(f Filter) ([]Entry, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if f.Guild != "" {
+		where = append(where, "guild = ?")
+		args = append(args, f.Guild)
+	}
+	if f.Channel != "" {
+		where = append(where, "channel = ?")
+		args = append(args, f.Channel)
+	}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, string(f.Status))
+	}
+	if f.Search != "" {
+		where = append(where, "(text LIKE ? OR trigger LIKE ?)")
+		args = append(args, "%"+f.Search+"%", "%"+f.Search+"%")
+	}
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+
+	q := `SELECT id, timestamp, source, guild, channel, reply, trigger, topic, persona, text, latency, status, moderator FROM ledger`
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += " ORDER BY id DESC LIMIT ? OFFSET ?;"
+	args = append(args, f.Limit, f.Offset)
+
+	rows, err := c.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry = make([]Entry, 0)
+	for rows.Next() {
+		var (
+			e         Entry
+			timestamp int64
+			latency   int64
+			status    string
+		)
+		err := rows.Scan(&e.ID, &timestamp, &e.Source, &e.Guild, &e.Channel, &e.Reply, &e.Trigger, &e.Topic, &e.Persona, &e.Text, &latency, &status, &e.Moderator)
+		if err != nil {
+			return nil, err
+		}
+		e.Timestamp = time.Unix(timestamp, 0)
+		e.Latency = time.Duration(latency) * time.Millisecond
+		e.Status = Status(status)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
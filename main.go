@@ -1,20 +1,28 @@
 package main
 
 import (
-	"database/sql"
-	"dmpsupport/helpers"
+	"bufio"
+	"context"
+	"dmpsupport/archive"
+	"dmpsupport/auth"
+	"dmpsupport/config"
+	"dmpsupport/cooldown"
+	"dmpsupport/ingest"
+	"dmpsupport/ledger"
+	"dmpsupport/queue"
+	"dmpsupport/replay"
+	"dmpsupport/replies"
 	"dmpsupport/rive"
+	"dmpsupport/suggest"
 	"flag"
 	"fmt"
-	"html/template"
 	"log"
-	"math"
 	"math/rand"
-	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -24,122 +32,106 @@ import (
 
 // https://discord.com/oauth2/authorize?client_id=1071810754623307926&scope=bot&permissions=117824
 
-type Messages struct {
-	ID      string
-	Guild   string
-	Channel string
-	Author  string
-	Content string
-}
+var (
+	debug bool
+	rs    *rive.Client
+
+	sentReplies    *replies.Store
+	messageArchive *archive.Client
+	replyLedger    *ledger.Client
+	suggestions    *suggest.Store
+	questions      *queue.Queue
+	logins         *auth.Store
+
+	current atomic.Pointer[config.Config]
+)
 
-var messages []Messages
+// conf returns the active configuration.
+func conf() *config.Config {
+	return current.Load()
+}
 
 func main() {
-	var debug bool
 	flag.BoolVar(&debug, "debug", false, "Debug mode, off by default")
 	var token string
-	flag.StringVar(&token, "token", "", "Discord Bot token.")
-	var guild string
-	flag.StringVar(&guild, "guild", "", "Discord Guilds to listen.")
-	var admin string
-	flag.StringVar(&admin, "admin", "", "Discord admins.")
-	var mute bool
-	flag.BoolVar(&mute, "mute", false, "mutes replys")
+	flag.StringVar(&token, "token", "", "Discord Bot token, overrides the token of the config file.")
+	var configFile string
+	flag.StringVar(&configFile, "config", "config.json", "Configuration file.")
+	var mine bool
+	flag.BoolVar(&mine, "mine", false, "Mine the archive for new trigger suggestions and exit.")
+	var corpus string
+	flag.StringVar(&corpus, "replay", "", "Replay the messages of an archive or JSONL file through the brain and exit.")
+	var brain, against string
+	flag.StringVar(&brain, "brain", "brain", "Brain directory used by -replay.")
+	flag.StringVar(&against, "diff", "", "Brain directory to compare with -brain when replaying.")
+	var addUser string
+	flag.StringVar(&addUser, "adduser", "", "Add a web UI user or change its password, read from stdin, and exit.")
 	flag.Parse()
 
-	if mute {
-		fmt.Println("Bot reply is muted")
+	c, err := config.Load(configFile)
+	if err != nil {
+		log.Fatal(err)
 	}
+	current.Store(c)
 
-	var guilds map[string]bool = make(map[string]bool)
-	for _, v := range strings.Split(guild, ",") {
-		guilds[v] = true
+	if addUser != "" {
+		addWebUser(addUser, c.DataDir)
+		return
+	}
+	if mine {
+		mineSuggestions(c.DataDir)
+		return
 	}
-	var admins map[string]bool = make(map[string]bool)
-	for _, v := range strings.Split(admin, ",") {
-		admins[v] = true
+	if corpus != "" {
+		if against != "" {
+			diffBrains(corpus, brain, against, c.DataDir)
+		} else {
+			replayCorpus(corpus, brain, c.DataDir)
+		}
+		return
+	}
+
+	if token == "" {
+		token = c.Token
 	}
 	if token == "" {
 		log.Fatal("no bot token defined, token is required")
 	}
 
-	rs := rive.New(debug)
+	rs = rive.New(debug, c.DataDir)
+	if rs == nil {
+		log.Fatal("could not load brain")
+	}
+	sentReplies = replies.New(filepath.Join(c.DataDir, "replies.db"))
+	messageArchive = archive.New(filepath.Join(c.DataDir, "messages.db"))
+	replyLedger = ledger.New(filepath.Join(c.DataDir, "ledger.db"))
+	suggestions = suggest.New(filepath.Join(c.DataDir, "suggestions.db"))
+	logins = auth.New(filepath.Join(c.DataDir, "auth.db"))
+	questionStore := queue.New(filepath.Join(c.DataDir, "queue.db"))
+	if questions, err = queue.NewQueue(questionStore, 100); err != nil {
+		log.Fatal(err)
+	}
 	dg, err := discordgo.New("Bot " + token)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	go func() {
-		mux := http.NewServeMux()
-		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			templ, err := template.ParseFS(os.DirFS("./www/templates"), "*.html")
-			if err != nil {
-				log.Fatal(err)
-			}
+	cooldowns := cooldown.New(limitsOf(c))
+	escalator := NewEscalator(dg)
+	go serveWeb(dg, escalator)
+	responder := NewResponder(dg, typingOf(c), cooldowns, ingest.New(), escalator)
 
-			switch r.Method {
-			case http.MethodGet:
-				m := messages
-				m = helpers.ReverseSlice(m)
-				templ.ExecuteTemplate(w, "index.html", m)
-			default:
-				http.Error(
-					w,
-					http.StatusText(http.StatusMethodNotAllowed),
-					http.StatusMethodNotAllowed,
-				)
-			}
-		})
-		mux.HandleFunc("/post", func(w http.ResponseWriter, r *http.Request) {
-			switch r.Method {
-			case http.MethodPost:
-				r.ParseForm()
-				if r.FormValue("id") != "" && r.FormValue("guild") != "" && r.FormValue("channel") != "" && r.FormValue("content") != "" && r.FormValue("trigger") != "" {
-					if r.FormValue("save") == "save" {
-						fmt.Println("learn new", r.FormValue("trigger"), r.FormValue("content"))
-						rs.LearnNew(r.FormValue("trigger"), r.FormValue("content"))
-					}
-					messages = mmFilter(r.FormValue("id"))
-					if !mute {
-						go func() {
-							err = dg.ChannelTyping(r.FormValue("channel"))
-							if err != nil {
-								log.Printf("Couldn't start typing: %v", err)
-							}
-
-							dg.ChannelMessageSendReply(r.FormValue("channel"), r.FormValue("content"), &discordgo.MessageReference{
-								MessageID: r.FormValue("id"),
-								GuildID:   r.FormValue("guild"),
-								ChannelID: r.FormValue("channel"),
-							})
-						}()
-					}
-				}
-				http.Redirect(w, r, "/", http.StatusFound)
-			default:
-				http.Error(
-					w,
-					http.StatusText(http.StatusMethodNotAllowed),
-					http.StatusMethodNotAllowed,
-				)
+	dg.AddHandler(func(s *discordgo.Session, m *discordgo.MessageUpdate) {
+		if archived(s, m.ChannelID) && m.Author != nil {
+			if err := messageArchive.Save(m.Message, parentOf(s, m.ChannelID)); err != nil {
+				log.Println("[ERR]", err)
 			}
-		})
-		mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./www/static"))))
-		srv := &http.Server{
-			Handler:           mux,
-			ReadTimeout:       time.Second * 15,
-			WriteTimeout:      time.Second * 15,
-			IdleTimeout:       time.Second * 15,
-			ReadHeaderTimeout: time.Second * 15,
-			Addr:              ":21616",
 		}
-
-		log.Fatal(srv.ListenAndServe())
-	}()
-
-	dg.AddHandler(func(s *discordgo.Session, m *discordgo.MessageUpdate) {
-		log.Println()
-		if m.Author == nil || s.State.User == nil || m.Author.ID == s.State.User.ID || !guilds[m.GuildID] || m.Content == "" {
+		// updates without an edit timestamp are link previews and pins
+		if m.EditedTimestamp == nil {
+			return
+		}
+		if m.Author == nil || s.State.User == nil || m.Author.ID == s.State.User.ID || !allowed(s, m.GuildID, m.ChannelID) || (m.Content == "" && len(m.Attachments) == 0) {
 			return
 		}
 
@@ -148,197 +140,97 @@ func main() {
 			log.Println(err)
 			return
 		}
-		if time.Since(t) > 5*time.Minute {
+		if _, err := sentReplies.Get(m.ID); err != nil && time.Since(t) > 5*time.Minute {
 			return
 		}
 
-		messages = mmFilter(m.ID)
-
-		if reply, err := rs.Reply(m.Author.ID, m.Content); err != nil {
-			messages = WebMessageQueue(Messages{
-				ID:      m.ID,
-				Guild:   m.GuildID,
-				Channel: m.ChannelID,
-				Author:  m.Author.Username,
-				Content: m.Content,
-			})
-			log.Println("[ERR]", err, m.Content)
-		} else if reply != "" {
-			log.Println("[INFO]", reply)
-			if !mute {
-				err := s.MessageReactionAdd(m.ChannelID, m.ID, "💬")
-				if err != nil {
-					log.Println("[ERR]", err)
-					return
-				}
-				defer s.MessageReactionRemove(m.ChannelID, m.ID, "💬", s.State.User.ID)
-
-				time.Sleep(time.Second * 5)
-				defer s.ChannelTyping("")
-
-				cpm := float64(len([]rune(reply)) * 150)
-				rounds := math.RoundToEven((cpm / 1000))
-
-				for i := 0; i < int(rounds)+int(RandomNumber(1, 2)); i = i + 1 {
-					err = s.ChannelTyping(m.ChannelID)
-					if err != nil {
-						log.Printf("Couldn't start typing: %v", err)
-					}
-					time.Sleep(1000 * time.Millisecond)
-				}
-
-				if _, err := s.ChannelMessageSendReply(m.ChannelID, reply, m.Reference()); err != nil {
-					log.Println("[ERR]", err)
-				}
-			}
-		}
+		questions.Remove(m.ID)
+		responder.Respond(forumPost(s, m.Message))
 	})
 
 	dg.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
-		if m.Author.ID == s.State.User.ID || !guilds[m.GuildID] || m.Content == "" {
-			return
-		}
-
-		if strings.HasPrefix(m.Content, "!reload") && admins[m.Author.ID] {
-			rs = rive.New(debug)
-			err = s.MessageReactionAdd(m.ChannelID, m.ID, "✅")
-			if err != nil {
+		if archived(s, m.ChannelID) {
+			if err := messageArchive.Save(m.Message, parentOf(s, m.ChannelID)); err != nil {
 				log.Println("[ERR]", err)
-				return
 			}
+		}
+		if !allowed(s, m.GuildID, m.ChannelID) {
 			return
 		}
-
-		if reply, err := rs.Reply(m.Author.ID, m.Content); err != nil {
-			messages = WebMessageQueue(Messages{
-				ID:      m.ID,
-				Guild:   m.GuildID,
-				Channel: m.ChannelID,
-				Author:  m.Author.Username,
-				Content: m.Content,
-			})
-			log.Println("[ERR]", err, m.Content)
-		} else if reply != "" {
-			log.Println("[INFO]", reply)
-			if !mute {
-				err := s.MessageReactionAdd(m.ChannelID, m.ID, "💬")
-				if err != nil {
-					log.Println("[ERR]", err)
-					return
-				}
-				defer s.MessageReactionRemove(m.ChannelID, m.ID, "💬", s.State.User.ID)
-				
-				time.Sleep(time.Second * 5)
-				defer s.ChannelTyping("")
-
-				cpm := float64(len([]rune(reply)) * 150)
-				rounds := math.RoundToEven((cpm / 1000))
-
-				for i := 0; i < int(rounds)+int(RandomNumber(1, 2)); i = i + 1 {
-					err = s.ChannelTyping(m.ChannelID)
-					if err != nil {
-						log.Printf("Couldn't start typing: %v", err)
-					}
-					time.Sleep(1000 * time.Millisecond)
-				}
-
-				if _, err := s.ChannelMessageSendReply(m.ChannelID, reply, m.Reference()); err != nil {
-					log.Println("[ERR]", err)
-				}
-			}
+		escalator.Answered(m.Message)
+		if m.Author.ID == s.State.User.ID || (m.Content == "" && len(m.Attachments) == 0) {
+			return
+		}
+		// opening posts of forum threads are answered by onThreadCreate
+		if m.ID == m.ChannelID && forumThread(s, m.ChannelID) != "" {
+			return
 		}
 
+		responder.Respond(m.Message)
 	})
 
-	dg.Identify.Intents = discordgo.IntentsGuildMessages
+	dg.AddHandler(onThreadCreate(responder))
 
-	if err = dg.Open(); err != nil {
-		log.Fatal("error opening connection,", err)
+	deleted := func(guild string, ids ...string) {
+		if conf().Guild(guild) == nil {
+			return
+		}
+		for _, id := range ids {
+			escalator.Cancel(id)
+			responder.Retract(id)
+			resolve(id, queue.StatusDismissed, "")
+		}
+		if err := messageArchive.MarkDeleted(ids...); err != nil {
+			log.Println("[ERR]", err)
+		}
 	}
+	dg.AddHandler(func(s *discordgo.Session, m *discordgo.MessageDelete) {
+		deleted(m.GuildID, m.ID)
+	})
+	dg.AddHandler(func(s *discordgo.Session, m *discordgo.MessageDeleteBulk) {
+		deleted(m.GuildID, m.Messages...)
+	})
 
-	{
-		var (
-			temp  []*discordgo.Message
-			after string = "1076998229574553692"
-			err   error
-		)
-		db, err := sql.Open("sqlite", "messages.db")
-		if err != nil {
-			log.Fatal(err)
-		}
+	dg.AddHandler(onInteractionCreate)
 
-		_, err = db.Exec(`PRAGMA journal_mode = 'WAL'; CREATE TABLE IF NOT EXISTS"messages" ("id" TEXT, "timestamp" INTEGER, "autor" TEXT, "content" TEXT, PRIMARY KEY("id"));`)
-		if err != nil {
-			log.Fatal(err)
-		}
+	dg.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildMessages
 
-		temp, err = dg.ChannelMessages("386904065558446081", 100, "", after, "")
-		if err != nil {
-			log.Fatal(err)
-		}
-		tx, err := db.Begin()
-		if err != nil {
-			log.Fatal(err)
-		}
-		stmt, err := tx.Prepare(`INSERT OR REPLACE INTO messages (id, timestamp, autor, content)VALUES(?,?,?,?);`)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer stmt.Close()
-		for _, v := range temp {
-			cm, err := discordgo.SnowflakeTimestamp(v.ID)
-			if err != nil {
-				log.Fatal(err)
-			}
-			if v.Content != "" {
-				_, err = stmt.Exec(v.ID, cm.UTC().Unix(), v.Author.Username, v.Content)
-				if err != nil {
-					log.Fatal(err)
-				}
-			}
-		}
+	if err = dg.Open(); err != nil {
+		log.Fatal("error opening connection,", err)
+	}
+	registerCommands(dg)
 
-		for len(temp) > 0 {
-			temp, err = dg.ChannelMessages("386904065558446081", 100, "", after, "")
-			if err != nil {
-				log.Fatal(err)
-			}
-			for _, v := range temp {
-				cm, err := discordgo.SnowflakeTimestamp(v.ID)
-				if err != nil {
-					log.Fatal(err)
-				}
-				bm, err := discordgo.SnowflakeTimestamp(after)
-				if err != nil {
-					log.Fatal(err)
-				}
-				if cm.Unix() > bm.Unix() {
-					fmt.Println(v.ID)
-					after = v.ID
-				}
-				if v.Content != "" {
-					_, err = stmt.Exec(v.ID, cm.UTC().Unix(), v.Author.Username, v.Content)
-					if err != nil {
-						log.Fatal(err)
-					}
-				}
-			}
+	// the archive catches up in the background, live messages are saved by the handlers
+	ctx, cancelBackfill := context.WithCancel(context.Background())
+	go messageArchive.Backfill(ctx, dg, c.Archive.Channels, c.Archive.After)
+
+	// Wait here until CTRL-C or other term signal is received.
+	fmt.Println("Bot is now running.  Press CTRL-C to exit.")
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, syscall.SIGHUP)
+	for sig := range sc {
+		if sig != syscall.SIGHUP {
+			break
 		}
-		err = tx.Commit()
+		c, err := config.Load(configFile)
 		if err != nil {
-			log.Fatal(err)
+			log.Println("[ERR]", "keeping the old configuration:", err)
+			continue
 		}
-		err = db.Close()
-		if err != nil {
-			log.Fatal(err)
+		if old := conf(); c.Listen != old.Listen || c.DataDir != old.DataDir {
+			log.Println("[WARN]", "changes to listen and data_dir take effect after a restart")
 		}
+		current.Store(c)
+		cancelBackfill()
+		ctx, cancelBackfill = context.WithCancel(context.Background())
+		go messageArchive.Backfill(ctx, dg, c.Archive.Channels, c.Archive.After)
+		responder.SetTyping(typingOf(c))
+		cooldowns.SetLimits(limitsOf(c))
+		registerCommands(dg)
+		log.Println("[INFO]", "configuration reloaded")
 	}
 
-	// Wait here until CTRL-C or other term signal is received.
-	fmt.Println("Bot is now running.  Press CTRL-C to exit.")
-	sc := make(chan os.Signal, 1)
-	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-	<-sc
+	cancelBackfill()
 
 	// Cleanly close down the Discord session.
 	if err := dg.Close(); err != nil {
@@ -349,38 +241,156 @@ func main() {
 	if err := rs.Close(); err != nil {
 		log.Fatal(err)
 	}
+	if err := sentReplies.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := messageArchive.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := replyLedger.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := suggestions.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := questionStore.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := logins.Close(); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func RandomNumber(min, max int) int {
-	return rand.Intn(max-min) + min
+// addWebUser sets the password of a local web UI account.
+func addWebUser(name, datadir string) {
+	fmt.Fprintf(os.Stderr, "Password for %s: ", name)
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		log.Fatal(err)
+	}
+	store := auth.New(filepath.Join(datadir, "auth.db"))
+	defer store.Close()
+	if err := store.SetUser(name, strings.TrimRight(password, "\r\n")); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("[INFO]", "saved user", name)
+}
+
+// mineSuggestions pairs archived questions with their human answers and stores
+// clusters of similar questions as trigger suggestions.
+func mineSuggestions(datadir string) {
+	a := archive.New(filepath.Join(datadir, "messages.db"))
+	defer a.Close()
+	store := suggest.New(filepath.Join(datadir, "suggestions.db"))
+	defer store.Close()
+
+	pairs, err := suggest.Pairs(a, 10*time.Minute)
+	if err != nil {
+		log.Fatal(err)
+	}
+	candidates := suggest.Cluster(pairs, 0.5, 2)
+	if err := store.Save(candidates); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("[INFO]", len(pairs), "answered questions,", len(candidates), "suggestions")
+}
+
+// replayCorpus answers recorded messages with a scratch copy of the brain and
+// prints what the bot would have replied.
+func replayCorpus(corpus, brain, datadir string) {
+	msgs, err := replay.Load(corpus)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c, err := rive.NewScratch(brain, datadir, 1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	replay.Summarize(replay.Run(c, msgs), 3).Write(os.Stdout, 50)
+}
+
+// diffBrains replays recorded messages through two brains and prints the
+// messages whose reply or matched trigger changed.
+func diffBrains(corpus, old, new, datadir string) {
+	msgs, err := replay.Load(corpus)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var results [2][]replay.Result
+	for i, brain := range []string{old, new} {
+		c, err := rive.NewScratch(brain, datadir, 1)
+		if err != nil {
+			log.Fatal(brain, ": ", err)
+		}
+		results[i] = replay.Run(c, msgs)
+		c.Close()
+	}
+
+	replay.WriteDiff(os.Stdout, replay.Diff(results[0], results[1]))
+}
+
+// allowed reports whether the bot answers messages in a channel.
+func allowed(s *discordgo.Session, guild, channel string) bool {
+	return conf().Allowed(guild, channel, parentOf(s, channel))
+}
+
+// muted reports whether replies in a channel are muted.
+func muted(s *discordgo.Session, guild, channel string) bool {
+	return conf().Muted(guild, channel, parentOf(s, channel))
 }
 
-// webui messages
-var mmlock sync.Mutex
+// archived reports whether messages of a channel, or of the channel a thread
+// belongs to, are archived.
+func archived(s *discordgo.Session, channel string) bool {
+	return conf().Archived(channel) || conf().Archived(parentOf(s, channel))
+}
 
-func WebMessageQueue(m Messages) []Messages {
-	mmlock.Lock()
-	defer mmlock.Unlock()
+// isAdmin reports whether a user is an admin of a guild, either by ID or by role.
+func isAdmin(guild string, user *discordgo.User, member *discordgo.Member) bool {
+	var roles []string
+	if member != nil {
+		roles = member.Roles
+	}
+	return conf().IsAdmin(guild, user.ID, roles)
+}
 
-	mm := messages
+// parentOf returns the parent channel of a thread, or "" for other channels.
+func parentOf(s *discordgo.Session, channel string) string {
+	c, err := s.State.Channel(channel)
+	if err != nil || !c.IsThread() {
+		return ""
+	}
+	return c.ParentID
+}
 
-	if len(mm) > 100 {
-		mm = mm[1:]
+func typingOf(c *config.Config) Typing {
+	return Typing{
+		Delay:    time.Duration(c.Typing.Delay),
+		PerChar:  time.Duration(c.Typing.PerChar),
+		Interval: time.Duration(c.Typing.Interval),
+		MinExtra: 1,
+		MaxExtra: 2,
 	}
+}
 
-	mm = append(mm, m)
-	return mm
+func limitsOf(c *config.Config) cooldown.Limits {
+	return cooldown.Limits{
+		User:    time.Duration(c.Cooldowns.User),
+		Channel: time.Duration(c.Cooldowns.Channel),
+		Trigger: time.Duration(c.Cooldowns.Trigger),
+	}
 }
 
-func mmFilter(id string) []Messages {
-	mmlock.Lock()
-	defer mmlock.Unlock()
+func RandomNumber(min, max int) int {
+	return rand.Intn(max-min) + min
+}
 
-	var tmp []Messages = make([]Messages, 0)
-	for _, v := range messages {
-		if v.ID != id {
-			tmp = append(tmp, v)
-		}
+// resolve removes a question from the web UI queue and records who handled it
+// and how.
+func resolve(id string, status queue.Status, by string) {
+	if err := questions.Resolve(id, status, by); err != nil {
+		log.Println("[ERR]", err)
 	}
-	return tmp
 }
@@ -0,0 +1,203 @@
+package queue
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotQueued = errors.New("the question is no longer queued")
+	ErrClaimed   = errors.New("the question is claimed by another moderator")
+)
+
+// Event is a change of the queue.
+type Event struct {
+	Type string // "add", "remove" or "claim"
+	Item Item   // only the ID is set for removals
+}
+
+// Queue holds the pending questions shown in the web UI and writes every
+// change through to a Store. It is safe for concurrent use.
+type Queue struct {
+	lock  sync.Mutex
+	items []Item // oldest first
+	limit int
+	store *Store
+
+	subscribers map[chan Event]bool
+}
+
+// NewQueue returns a queue of at most limit questions, starting with the most
+// recent pending questions of the store. A nil store keeps the queue in memory
+// only.
+func NewQueue(store *Store, limit int) (*Queue, error) {
+	q := &Queue{limit: limit, store: store, subscribers: make(map[chan Event]bool)}
+	if store == nil {
+		return q, nil
+	}
+	pending, err := store.List(StatusPending, limit, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(pending) - 1; i >= 0; i-- {
+		q.items = append(q.items, pending[i])
+	}
+	return q, nil
+}
+
+// Add queues a question, replacing an earlier version of it. The oldest
+// question is dropped from the queue, but stays pending in the store, when the
+// queue is full.
+func (q *Queue) Add(i Item) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if n := q.index(i.ID); n >= 0 {
+		i.ClaimedBy, i.ClaimExpires = q.items[n].ClaimedBy, q.items[n].ClaimExpires
+	}
+	q.remove(i.ID)
+	q.items = append(q.items, i)
+	q.publish(Event{Type: "add", Item: i})
+	if q.limit > 0 && len(q.items) > q.limit {
+		for _, dropped := range q.items[:len(q.items)-q.limit] {
+			q.publish(Event{Type: "remove", Item: Item{ID: dropped.ID}})
+		}
+		q.items = append([]Item(nil), q.items[len(q.items)-q.limit:]...)
+	}
+	if q.store == nil {
+		return nil
+	}
+	return q.store.Add(i)
+}
+
+// Remove drops a question from the queue without changing its status, for
+// example while an edited question is answered again.
+func (q *Queue) Remove(id string) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.remove(id)
+}
+
+// Resolve removes a question and records who handled it and how.
+func (q *Queue) Resolve(id string, status Status, by string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.remove(id)
+	if q.store == nil {
+		return nil
+	}
+	return q.store.Resolve(id, status, by)
+}
+
+// Claim reserves a question for a moderator for ttl, or extends their claim.
+// It fails with ErrClaimed while another moderator holds a claim.
+func (q *Queue) Claim(id, by string, ttl time.Duration) (Item, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	n := q.index(id)
+	if n < 0 {
+		return Item{}, ErrNotQueued
+	}
+	item := &q.items[n]
+	if item.Claimed(by) {
+		return *item, ErrClaimed
+	}
+	item.ClaimedBy = by
+	item.ClaimExpires = time.Now().Add(ttl)
+	q.publish(Event{Type: "claim", Item: *item})
+	return *item, nil
+}
+
+// Release gives up the claim of a moderator on a question.
+func (q *Queue) Release(id, by string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	n := q.index(id)
+	if n < 0 {
+		return ErrNotQueued
+	}
+	item := &q.items[n]
+	if item.Claimed(by) {
+		return ErrClaimed
+	}
+	item.ClaimedBy = ""
+	item.ClaimExpires = time.Time{}
+	q.publish(Event{Type: "claim", Item: *item})
+	return nil
+}
+
+// Snapshot returns a copy of the queued questions, newest first.
+func (q *Queue) Snapshot() []Item {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.snapshot()
+}
+
+func (q *Queue) snapshot() []Item {
+	items := make([]Item, len(q.items))
+	for i, item := range q.items {
+		if !item.Claimed("") {
+			item.ClaimedBy, item.ClaimExpires = "", time.Time{}
+		}
+		items[len(items)-1-i] = item
+	}
+	return items
+}
+
+// index returns the position of a question in the queue, or -1.
+func (q *Queue) index(id string) int {
+	for i, item := range q.items {
+		if item.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Subscribe returns the snapshot of the queue and a channel receiving every
+// later change. The channel is closed if the subscriber falls too far behind,
+// or when cancel is called.
+func (q *Queue) Subscribe() (snapshot []Item, events <-chan Event, cancel func()) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	c := make(chan Event, 64)
+	q.subscribers[c] = true
+	return q.snapshot(), c, func() {
+		q.lock.Lock()
+		defer q.lock.Unlock()
+
+		if q.subscribers[c] {
+			delete(q.subscribers, c)
+			close(c)
+		}
+	}
+}
+
+func (q *Queue) publish(e Event) {
+	for c := range q.subscribers {
+		select {
+		case c <- e:
+		default:
+			delete(q.subscribers, c)
+			close(c)
+		}
+	}
+}
+
+func (q *Queue) remove(id string) bool {
+	for i, item := range q.items {
+		if item.ID == id {
+			q.items = append(q.items[:i:i], q.items[i+1:]...)
+			q.publish(Event{Type: "remove", Item: Item{ID: id}})
+			return true
+		}
+	}
+	return false
+}
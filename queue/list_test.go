@@ -0,0 +1,167 @@
+package queue
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueueConcurrent(t *testing.T) {
+	store := New(filepath.Join(t.TempDir(), "queue.db"))
+	defer store.Close()
+	q, err := NewQueue(store, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				id := fmt.Sprintf("%d-%d", w, i)
+				if err := q.Add(Item{ID: id, Content: "question " + id}); err != nil {
+					t.Error(err)
+				}
+				q.Claim(id, fmt.Sprint("mod", w), time.Minute)
+				q.Snapshot()
+				switch i % 3 {
+				case 0:
+					q.Remove(id)
+				case 1:
+					if err := q.Resolve(id, StatusAnswered, "bot"); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+		}(w)
+	}
+	for s := 0; s < 4; s++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				_, events, cancel := q.Subscribe()
+				select {
+				case <-events:
+				case <-time.After(time.Millisecond):
+				}
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(q.Snapshot()); n > 20 {
+		t.Errorf("queue holds %d items, limit is 20", n)
+	}
+}
+
+func TestQueueLimit(t *testing.T) {
+	q, _ := NewQueue(nil, 3)
+	_, events, cancel := q.Subscribe()
+	defer cancel()
+
+	for i := 1; i <= 5; i++ {
+		q.Add(Item{ID: fmt.Sprint(i)})
+	}
+	got := ids(q.Snapshot())
+	if want := "5,4,3"; got != want {
+		t.Errorf("Snapshot() = %s, want %s", got, want)
+	}
+
+	var removed []string
+	for len(events) > 0 {
+		if e := <-events; e.Type == "remove" {
+			removed = append(removed, e.Item.ID)
+		}
+	}
+	if fmt.Sprint(removed) != "[1 2]" {
+		t.Errorf("removed %v, want [1 2]", removed)
+	}
+}
+
+func TestQueueSnapshotOrder(t *testing.T) {
+	q, _ := NewQueue(nil, 10)
+	q.Add(Item{ID: "a"})
+	q.Add(Item{ID: "b"})
+	q.Add(Item{ID: "c"})
+	// adding a question again moves it to the front
+	q.Add(Item{ID: "a"})
+	q.Remove("b")
+
+	if got, want := ids(q.Snapshot()), "a,c"; got != want {
+		t.Errorf("Snapshot() = %s, want %s", got, want)
+	}
+	snapshot, _, cancel := q.Subscribe()
+	cancel()
+	if got, want := ids(snapshot), "a,c"; got != want {
+		t.Errorf("Subscribe() snapshot = %s, want %s", got, want)
+	}
+}
+
+func TestQueueSlowSubscriber(t *testing.T) {
+	q, _ := NewQueue(nil, 0)
+	_, events, cancel := q.Subscribe()
+	defer cancel()
+
+	for i := 0; i < 100; i++ {
+		q.Add(Item{ID: fmt.Sprint(i)})
+	}
+	n := 0
+	for range events {
+		n++
+	}
+	if n >= 100 {
+		t.Errorf("received all %d events, want the channel closed early", n)
+	}
+	// cancelling a closed subscription must not panic
+	cancel()
+}
+
+func TestQueueClaim(t *testing.T) {
+	q, _ := NewQueue(nil, 10)
+	q.Add(Item{ID: "1"})
+
+	if _, err := q.Claim("1", "alice", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if item, err := q.Claim("1", "bob", time.Minute); err != ErrClaimed || item.ClaimedBy != "alice" {
+		t.Errorf("Claim by bob = %q, %v, want alice, ErrClaimed", item.ClaimedBy, err)
+	}
+	if err := q.Release("1", "bob"); err != ErrClaimed {
+		t.Errorf("Release by bob = %v, want ErrClaimed", err)
+	}
+	// edits keep the claim
+	q.Add(Item{ID: "1", Content: "edited"})
+	if got := q.Snapshot()[0].ClaimedBy; got != "alice" {
+		t.Errorf("claim after edit = %q, want alice", got)
+	}
+	if err := q.Release("1", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Claim("1", "bob", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// expired claims are free
+	if _, err := q.Claim("1", "alice", time.Minute); err != nil {
+		t.Errorf("Claim after expiry = %v", err)
+	}
+	if _, err := q.Claim("2", "alice", time.Minute); err != ErrNotQueued {
+		t.Errorf("Claim of unknown question = %v, want ErrNotQueued", err)
+	}
+}
+
+func ids(items []Item) string {
+	s := ""
+	for i, item := range items {
+		if i > 0 {
+			s += ","
+		}
+		s += item.ID
+	}
+	return s
+}
@@ -0,0 +1,133 @@
+// Package queue stores the questions the bot could not answer until a
+// moderator handles them.
+package queue
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusAnswered  Status = "answered"  // a moderator or the bot replied
+	StatusDismissed Status = "dismissed" // dropped without a reply
+	StatusLearned   Status = "learned"   // answered and learned as a new trigger
+)
+
+type Item struct {
+	ID        string // Discord message ID
+	Guild     string
+	Channel   string
+	Author    string
+	Content   string
+	Status    Status
+	HandledBy string
+	Added     time.Time
+	Handled   time.Time // zero while pending
+
+	// moderator working on the question, only kept in the Queue
+	ClaimedBy    string
+	ClaimExpires time.Time
+}
+
+// Claimed reports whether someone else than user holds an unexpired claim.
+func (i Item) Claimed(user string) bool {
+	return i.ClaimedBy != "" && i.ClaimedBy != user && time.Now().Before(i.ClaimExpires)
+}
+
+type Store struct {
+	db   *sql.DB
+	lock sync.Mutex
+}
+
+func New(filename string) *Store {
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(`
+	PRAGMA journal_mode = 'WAL';
+	BEGIN TRANSACTION;
+	CREATE TABLE IF NOT EXISTS "queue" (
+		"id"	TEXT NOT NULL,
+		"guild"	TEXT NOT NULL,
+		"channel"	TEXT NOT NULL,
+		"author"	TEXT NOT NULL,
+		"content"	TEXT NOT NULL,
+		"status"	TEXT NOT NULL DEFAULT 'pending',
+		"handled_by"	TEXT NOT NULL DEFAULT '',
+		"added"	INTEGER NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+		"handled"	INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY("id")
+	);
+	CREATE INDEX IF NOT EXISTS "queue_status" ON "queue" ("status", "added");
+	COMMIT;`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &Store{
+		db: db,
+	}
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Add queues a question. A question that is queued again, for example after
+// an edit, becomes pending again but keeps the time it was first added.
+func (s *Store) Add(i Item) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err := s.db.Exec(`INSERT INTO queue (id, guild, channel, author, content)VALUES(?,?,?,?,?)
+	ON CONFLICT (id) DO UPDATE SET content = excluded.content, status = 'pending', handled_by = '', handled = 0;`,
+		i.ID, i.Guild, i.Channel, i.Author, i.Content)
+	return err
+}
+
+// Resolve records who handled a pending question and how. Questions that are
+// not pending are left alone.
+func (s *Store) Resolve(id string, status Status, by string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err := s.db.Exec(`UPDATE queue SET status = ?, handled_by = ?, handled = ? WHERE id = ? AND status = 'pending';`,
+		status, by, time.Now().Unix(), id)
+	return err
+}
+
+// List returns the questions with a status, newest first. An empty status
+// matches every question.
+func (s *Store) List(status Status, limit, offset int) ([]Item, error) {
+	rows, err := s.db.Query(`SELECT id, guild, channel, author, content, status, handled_by, added, handled
+	FROM queue WHERE ? = '' OR status = ? ORDER BY added DESC, id DESC LIMIT ? OFFSET ?;`,
+		status, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		var (
+			i              Item
+			added, handled int64
+		)
+		if err := rows.Scan(&i.ID, &i.Guild, &i.Channel, &i.Author, &i.Content, &i.Status, &i.HandledBy, &added, &handled); err != nil {
+			return nil, err
+		}
+		i.Added = time.Unix(added, 0)
+		if handled != 0 {
+			i.Handled = time.Unix(handled, 0)
+		}
+		items = append(items, i)
+	}
+	return items, rows.Err()
+}
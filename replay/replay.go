@@ -0,0 +1,277 @@
+// Package replay runs recorded messages through the brain to see how the bot
+// would have answered them, without connecting to Discord.
+package replay
+
+import (
+	"bufio"
+	"dmpsupport/archive"
+	"dmpsupport/rive"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Message is a recorded message. JSONL corpora use one object per line with
+// these fields, only content is required.
+type Message struct {
+	ID      string `json:"id"`
+	Author  string `json:"author"`
+	Content string `json:"content"`
+}
+
+// Load reads a corpus. Files ending in .jsonl hold one Message per line,
+// anything else is opened as a message archive, which is never modified.
+func Load(filename string) ([]Message, error) {
+	if strings.EqualFold(filepath.Ext(filename), ".jsonl") {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return JSONL(f)
+	}
+	a, err := archive.OpenReadOnly(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+	return Archive(a)
+}
+
+// JSONL reads one Message per line. Empty lines are skipped.
+func JSONL(r io.Reader) ([]Message, error) {
+	var msgs []Message
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var m Message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if m.ID == "" {
+			m.ID = fmt.Sprint(line)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, scanner.Err()
+}
+
+// Archive returns the archived messages of humans in the order they were sent
+// in each channel.
+func Archive(a *archive.Client) ([]Message, error) {
+	var msgs []Message
+	err := a.Each(func(m archive.Message) error {
+		if m.Bot || strings.TrimSpace(m.Content) == "" {
+			return nil
+		}
+		author := m.AuthorID
+		if author == "" {
+			author = m.Author
+		}
+		msgs = append(msgs, Message{ID: m.ID, Author: author, Content: m.Content})
+		return nil
+	})
+	return msgs, err
+}
+
+// Result is the answer of the brain to a message. Trigger is empty if no
+// trigger matched.
+type Result struct {
+	Message
+	Reply   string
+	Trigger string
+}
+
+// Run sends every message through the brain in order, so conversations keep
+// their context. The client should use a scratch session store.
+func Run(c *rive.Client, msgs []Message) []Result {
+	results := make([]Result, 0, len(msgs))
+	for _, m := range msgs {
+		author := m.Author
+		if author == "" {
+			author = "replay"
+		}
+		r := Result{Message: m}
+		if match, err := c.Match(author, m.Content); err == nil {
+			r.Reply = match.Reply
+			r.Trigger = match.Trigger
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// Trigger counts how often a trigger fired.
+type Trigger struct {
+	Trigger string
+	Count   int
+	Samples []Result // the first few messages that fired the trigger
+}
+
+// Report summarises a replay.
+type Report struct {
+	Total       int
+	Matched     int
+	Triggers    []Trigger // most used first
+	FallThrough []Message // messages no trigger matched
+}
+
+// Summarize builds a report keeping up to samples example replies per trigger.
+func Summarize(results []Result, samples int) Report {
+	r := Report{Total: len(results)}
+	triggers := make(map[string]*Trigger)
+	for _, res := range results {
+		if res.Trigger == "" {
+			r.FallThrough = append(r.FallThrough, res.Message)
+			continue
+		}
+		r.Matched++
+		t, ok := triggers[res.Trigger]
+		if !ok {
+			t = &Trigger{Trigger: res.Trigger}
+			triggers[res.Trigger] = t
+		}
+		t.Count++
+		if len(t.Samples) < samples {
+			t.Samples = append(t.Samples, res)
+		}
+	}
+	for _, t := range triggers {
+		r.Triggers = append(r.Triggers, *t)
+	}
+	sort.Slice(r.Triggers, func(i, j int) bool {
+		if r.Triggers[i].Count != r.Triggers[j].Count {
+			return r.Triggers[i].Count > r.Triggers[j].Count
+		}
+		return r.Triggers[i].Trigger < r.Triggers[j].Trigger
+	})
+	return r
+}
+
+// MatchRate returns the share of messages a trigger matched.
+func (r Report) MatchRate() float64 {
+	if r.Total == 0 {
+		return 0
+	}
+	return float64(r.Matched) / float64(r.Total)
+}
+
+// Write prints the report as plain text, listing at most fallThrough
+// unmatched messages.
+func (r Report) Write(w io.Writer, fallThrough int) {
+	fmt.Fprintf(w, "%d messages, %d matched (%.1f%%), %d triggers fired\n\n", r.Total, r.Matched, 100*r.MatchRate(), len(r.Triggers))
+	fmt.Fprintln(w, "Triggers:")
+	for _, t := range r.Triggers {
+		fmt.Fprintf(w, "%6d  %s\n", t.Count, t.Trigger)
+		for _, s := range t.Samples {
+			fmt.Fprintf(w, "        > %s\n        < %s\n", oneLine(s.Content), oneLine(s.Reply))
+		}
+	}
+	fmt.Fprintf(w, "\nFell through (%d):\n", len(r.FallThrough))
+	for i, m := range r.FallThrough {
+		if i == fallThrough {
+			fmt.Fprintf(w, "        … %d more\n", len(r.FallThrough)-i)
+			break
+		}
+		fmt.Fprintf(w, "        %s: %s\n", m.ID, oneLine(m.Content))
+	}
+}
+
+// oneLine shortens text to a single line of at most 120 characters.
+func oneLine(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > 120 {
+		s = string(r[:119]) + "…"
+	}
+	return s
+}
+
+// Change is a message the two brains of a diff answered differently.
+type Change struct {
+	Message
+	Old, New Result
+}
+
+// Diff compares two replays of the same messages and groups the messages whose
+// reply or matched trigger changed by the trigger of the old brain, or the new
+// one for messages the old brain did not match.
+//
+// Both replays share one random seed, but a changed brain draws the random
+// numbers in a different order. A different reply of the same trigger therefore
+// only counts when one of the replies was never given for the trigger in the
+// other replay.
+func Diff(old, new []Result) map[string][]Change {
+	oldReplies, newReplies := replies(old), replies(new)
+	changes := make(map[string][]Change)
+	for i := range old {
+		if i >= len(new) {
+			break
+		}
+		o, n := old[i], new[i]
+		if o.Trigger == n.Trigger {
+			if o.Reply == n.Reply {
+				continue
+			}
+			if oldReplies[o.Trigger][n.Reply] && newReplies[n.Trigger][o.Reply] {
+				continue
+			}
+		}
+		key := o.Trigger
+		if key == "" {
+			key = n.Trigger
+		}
+		changes[key] = append(changes[key], Change{Message: o.Message, Old: o, New: n})
+	}
+	return changes
+}
+
+// replies returns the replies given for each trigger.
+func replies(results []Result) map[string]map[string]bool {
+	m := make(map[string]map[string]bool)
+	for _, r := range results {
+		if m[r.Trigger] == nil {
+			m[r.Trigger] = make(map[string]bool)
+		}
+		m[r.Trigger][r.Reply] = true
+	}
+	return m
+}
+
+// WriteDiff prints the changes of a diff grouped by trigger.
+func WriteDiff(w io.Writer, changes map[string][]Change) {
+	keys := make([]string, 0, len(changes))
+	total := 0
+	for k, c := range changes {
+		keys = append(keys, k)
+		total += len(c)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "%d messages changed under %d triggers\n", total, len(keys))
+	for _, k := range keys {
+		fmt.Fprintf(w, "\n%s (%d)\n", k, len(changes[k]))
+		for _, c := range changes[k] {
+			fmt.Fprintf(w, "    > %s\n", oneLine(c.Content))
+			if c.Old.Trigger != c.New.Trigger {
+				fmt.Fprintf(w, "      trigger: %s -> %s\n", orNone(c.Old.Trigger), orNone(c.New.Trigger))
+			}
+			if c.Old.Reply != c.New.Reply {
+				fmt.Fprintf(w, "      - %s\n      + %s\n", oneLine(c.Old.Reply), oneLine(c.New.Reply))
+			}
+		}
+	}
+}
+
+func orNone(trigger string) string {
+	if trigger == "" {
+		return "(none)"
+	}
+	return trigger
+}
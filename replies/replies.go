@@ -0,0 +1,85 @@
+// Package replies remembers which message the bot sent in reply to which
+// Discord message, so replies can be edited or removed later on.
+package replies
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+var ErrNotFound = errors.New("no reply found")
+
+type Reply struct {
+	Source  string // message that triggered the reply
+	Channel string
+	Reply   string // message the bot sent
+}
+
+type Store struct {
+	db   *sql.DB
+	lock sync.Mutex
+}
+
+func New(filename string) *Store {
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(`
+	PRAGMA journal_mode = 'WAL';
+	BEGIN TRANSACTION;
+	CREATE TABLE IF NOT EXISTS "replies" (
+		"source"	TEXT NOT NULL,
+		"channel"	TEXT NOT NULL,
+		"reply"	TEXT NOT NULL,
+		"timestamp"	INTEGER NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+		PRIMARY KEY("source")
+	);
+	COMMIT;`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &Store{
+		db: db,
+	}
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Set stores the reply sent for a source message.
+func (s *Store) Set(r Reply) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO replies (source, channel, reply)VALUES(?,?,?);`, r.Source, r.Channel, r.Reply)
+	return err
+}
+
+// Get returns the reply sent for a source message.
+func (s *Store) Get(source string) (Reply, error) {
+	r := Reply{Source: source}
+	row := s.db.QueryRow(`SELECT channel, reply FROM replies WHERE source = ?;`, source)
+	switch err := row.Scan(&r.Channel, &r.Reply); err {
+	case sql.ErrNoRows:
+		return r, ErrNotFound
+	case nil:
+		return r, nil
+	default:
+		return r, err
+	}
+}
+
+// Delete forgets the reply of a source message.
+func (s *Store) Delete(source string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err := s.db.Exec(`DELETE FROM replies WHERE source = ?;`, source)
+	return err
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"dmpsupport/cooldown"
+	"dmpsupport/ingest"
+	"dmpsupport/ledger"
+	"dmpsupport/queue"
+	"dmpsupport/replies"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Typing controls how the bot simulates a human typing a reply.
+type Typing struct {
+	Delay    time.Duration // pause after reacting before the bot starts typing
+	PerChar  time.Duration // typing time per character of the reply
+	Interval time.Duration // how often the typing indicator is refreshed
+	MinExtra int           // minimum extra typing rounds
+	MaxExtra int           // maximum extra typing rounds (exclusive)
+}
+
+// Rounds returns how many typing indicator refreshes a reply needs.
+func (t Typing) Rounds(reply string) int {
+	if t.Interval <= 0 {
+		return 0
+	}
+	cpm := float64(len([]rune(reply))) * float64(t.PerChar)
+	rounds := int(math.RoundToEven(cpm / float64(t.Interval)))
+	if t.MaxExtra > t.MinExtra {
+		rounds += RandomNumber(t.MinExtra, t.MaxExtra)
+	} else {
+		rounds += t.MinExtra
+	}
+	return rounds
+}
+
+type job struct {
+	cancel context.CancelFunc
+}
+
+// Responder owns one reply job per incoming Discord message. A job is
+// cancelled when its message is edited again or deleted, so a stale answer is
+// never posted.
+type Responder struct {
+	s         *discordgo.Session
+	typing    Typing
+	cooldowns *cooldown.Limiter
+	ingest    *ingest.Client
+	escalator *Escalator
+
+	lock sync.Mutex
+	jobs map[string]*job
+}
+
+func NewResponder(s *discordgo.Session, typing Typing, cooldowns *cooldown.Limiter, ingest *ingest.Client, escalator *Escalator) *Responder {
+	return &Responder{
+		s:         s,
+		typing:    typing,
+		cooldowns: cooldowns,
+		ingest:    ingest,
+		escalator: escalator,
+		jobs:      make(map[string]*job),
+	}
+}
+
+// SetTyping changes the typing simulation of future reply jobs.
+func (r *Responder) SetTyping(typing Typing) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.typing = typing
+}
+
+// Respond starts a reply job for m, cancelling a job still running for the
+// same message.
+func (r *Responder) Respond(m *discordgo.Message) {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{cancel: cancel}
+
+	r.lock.Lock()
+	if old, ok := r.jobs[m.ID]; ok {
+		old.cancel()
+	}
+	r.jobs[m.ID] = j
+	typing := r.typing
+	r.lock.Unlock()
+
+	go func() {
+		defer r.done(m.ID, j)
+		r.run(ctx, m, typing)
+	}()
+}
+
+// Cancel stops the reply job of a message, if any.
+func (r *Responder) Cancel(id string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if j, ok := r.jobs[id]; ok {
+		j.cancel()
+		delete(r.jobs, id)
+	}
+}
+
+// Retract cancels the reply job of a message and deletes the reply the bot
+// already sent for it.
+func (r *Responder) Retract(id string) {
+	r.Cancel(id)
+
+	prev, err := sentReplies.Get(id)
+	switch err {
+	case nil:
+		r.retract(prev)
+	case replies.ErrNotFound:
+	default:
+		log.Println("[ERR]", err)
+	}
+}
+
+func (r *Responder) done(id string, j *job) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	j.cancel()
+	if r.jobs[id] == j {
+		delete(r.jobs, id)
+	}
+}
+
+func (r *Responder) run(ctx context.Context, m *discordgo.Message, typing Typing) {
+	prev, err := sentReplies.Get(m.ID)
+	if err != nil && err != replies.ErrNotFound {
+		log.Println("[ERR]", err)
+	}
+	edit := err == nil
+
+	// errors hidden in log files and pastes are matched along with the text
+	text := m.Content
+	if errs := r.ingest.Extract(ctx, m); errs != "" {
+		text = strings.TrimSpace(text + "\n" + errs)
+	}
+	if text == "" || ctx.Err() != nil {
+		return
+	}
+
+	session := sessionName(r.s, m)
+	match, err := rs.Match(session, text)
+	reply := match.Reply
+	if session != m.Author.ID {
+		reply = strings.ReplaceAll(reply, "<@"+session+">", "<@"+m.Author.ID+">")
+	}
+	if err != nil || reply == "" {
+		if ctx.Err() != nil {
+			return
+		}
+		if edit {
+			r.retract(prev)
+		}
+		r.escalate(m, text)
+		log.Println("[ERR]", err, text)
+		return
+	}
+	log.Println("[INFO]", reply)
+	entry := ledger.Entry{
+		Source:  m.ID,
+		Guild:   m.GuildID,
+		Channel: m.ChannelID,
+		Trigger: match.Trigger,
+		Topic:   match.Topic,
+		Persona: match.Persona,
+		Text:    reply,
+		Status:  ledger.StatusMuted,
+	}
+	if muted(r.s, m.GuildID, m.ChannelID) {
+		r.record(m, entry)
+		return
+	}
+	// the cooldown is only counted once the reply is sent, so an edit or a
+	// link preview during the typing delay doesn't use it up
+	limited := !edit && !isAdmin(m.GuildID, m.Author, m.Member)
+	if limited {
+		if ok, limit := r.cooldowns.Check(m.Author.ID, m.ChannelID, match.Trigger); !ok {
+			r.suppress(m, text, entry, limit)
+			return
+		}
+	}
+
+	if edit {
+		if ctx.Err() != nil {
+			return
+		}
+		_, err := r.s.ChannelMessageEdit(prev.Channel, prev.Reply, reply)
+		if err == nil {
+			entry.Reply = prev.Reply
+			entry.Status = ledger.StatusEdited
+			r.record(m, entry)
+			resolve(m.ID, queue.StatusAnswered, "bot")
+			return
+		}
+		// our reply is gone, answer with a new one instead
+		log.Println("[ERR]", err)
+		if err := sentReplies.Delete(m.ID); err != nil {
+			log.Println("[ERR]", err)
+		}
+	}
+
+	if err := r.s.MessageReactionAdd(m.ChannelID, m.ID, "💬"); err != nil {
+		log.Println("[ERR]", err)
+		return
+	}
+	defer r.s.MessageReactionRemove(m.ChannelID, m.ID, "💬", r.s.State.User.ID)
+
+	if !sleep(ctx, typing.Delay) {
+		return
+	}
+
+	for i := 0; i < typing.Rounds(reply); i++ {
+		if err := r.s.ChannelTyping(m.ChannelID); err != nil {
+			log.Printf("Couldn't start typing: %v", err)
+		}
+		if !sleep(ctx, typing.Interval) {
+			return
+		}
+	}
+
+	if limited {
+		if ok, limit := r.cooldowns.Allow(m.Author.ID, m.ChannelID, match.Trigger); !ok {
+			r.suppress(m, text, entry, limit)
+			return
+		}
+	}
+	sent, err := r.s.ChannelMessageSendReply(m.ChannelID, reply, m.Reference())
+	if err != nil {
+		log.Println("[ERR]", err)
+		return
+	}
+	if err := sentReplies.Set(replies.Reply{
+		Source:  m.ID,
+		Channel: m.ChannelID,
+		Reply:   sent.ID,
+	}); err != nil {
+		log.Println("[ERR]", err)
+	}
+	entry.Reply = sent.ID
+	entry.Status = ledger.StatusSent
+	r.record(m, entry)
+	resolve(m.ID, queue.StatusAnswered, "bot")
+	tagAnswered(r.s, m.ChannelID)
+}
+
+// suppress records a reply blocked by a cooldown and queues the question for
+// the moderators. It doesn't escalate, so repeated questions don't ping the
+// helpers every time.
+func (r *Responder) suppress(m *discordgo.Message, text string, e ledger.Entry, limit string) {
+	log.Println("[INFO]", "reply suppressed by", limit, "cooldown")
+	e.Status = ledger.StatusCooldown
+	r.record(m, e)
+	r.enqueue(m, text)
+}
+
+// escalate queues a question the bot didn't answer and schedules pinging the
+// helpers about it.
+func (r *Responder) escalate(m *discordgo.Message, text string) {
+	r.enqueue(m, text)
+	r.escalator.Schedule(m, text)
+}
+
+// enqueue adds a question to the moderation queue.
+func (r *Responder) enqueue(m *discordgo.Message, text string) {
+	if err := questions.Add(queue.Item{
+		ID:      m.ID,
+		Guild:   m.GuildID,
+		Channel: m.ChannelID,
+		Author:  m.Author.Username,
+		Content: text,
+	}); err != nil {
+		log.Println("[ERR]", err)
+	}
+}
+
+// record adds a reply to the ledger, measuring the latency from the moment the
+// source message was sent or last edited.
+func (r *Responder) record(m *discordgo.Message, e ledger.Entry) {
+	if m.EditedTimestamp != nil {
+		e.Latency = time.Since(*m.EditedTimestamp)
+	} else if t, err := discordgo.SnowflakeTimestamp(m.ID); err == nil {
+		e.Latency = time.Since(t)
+	}
+	if err := replyLedger.Record(e); err != nil {
+		log.Println("[ERR]", err)
+	}
+}
+
+// retract deletes a reply the bot sent earlier.
+func (r *Responder) retract(prev replies.Reply) {
+	if err := r.s.ChannelMessageDelete(prev.Channel, prev.Reply); err != nil {
+		log.Println("[ERR]", err)
+	}
+	if err := sentReplies.Delete(prev.Source); err != nil {
+		log.Println("[ERR]", err)
+	}
+}
+
+// sleep waits for d and reports false if ctx was cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,191 @@
+package rive
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aichaos/rivescript-go/parser"
+)
+
+// SyntaxError is a problem the parser found on a line of a brain file.
+type SyntaxError struct {
+	Line    int
+	Message string
+}
+
+// SyntaxErrors are all problems of a brain file.
+type SyntaxErrors []SyntaxError
+
+func (e SyntaxErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = fmt.Sprintf("line %d: %s", err.Line, err.Message)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Check parses the source of a brain file with strict syntax checking. Every
+// warning of the parser counts as an error.
+func Check(name, source string) error {
+	var errs SyntaxErrors
+	p := parser.New(parser.ParserConfig{
+		Strict: true,
+		UTF8:   true,
+		OnWarn: func(message, filename string, lineno int, a ...interface{}) {
+			errs = append(errs, SyntaxError{Line: lineno, Message: fmt.Sprintf(message, a...)})
+		},
+	})
+	if _, err := p.Parse(name, strings.Split(source, "\n")); err != nil {
+		errs = append(errs, SyntaxError{Message: err.Error()})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// BrainFiles lists the files of the brain directory.
+func (c *Client) BrainFiles() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(c.brain, "*.rive"))
+	if err != nil {
+		return nil, err
+	}
+	for i := range files {
+		files[i] = filepath.Base(files[i])
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// BrainFile returns the source of a brain file.
+func (c *Client) BrainFile(name string) (string, error) {
+	if err := brainName(name); err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(filepath.Join(c.brain, name))
+	return string(b), err
+}
+
+// SaveBrainFile replaces a brain file, or creates a new one. The file must
+// pass Check and the whole brain directory must load and sort with it,
+// otherwise the file is left unchanged. The live brain is swapped once
+// everything loaded, and the previous version of the file is kept in the
+// history directory.
+func (c *Client) SaveBrainFile(name, source string) error {
+	if err := brainName(name); err != nil {
+		return err
+	}
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	if err := Check(name, source); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	path := filepath.Join(c.brain, name)
+	old, err := os.ReadFile(path)
+	existed := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if existed && string(old) == source {
+		return nil
+	}
+
+	if err := writeFile(path, []byte(source)); err != nil {
+		return err
+	}
+	r, err := c.load()
+	if err != nil {
+		if existed {
+			err = fmt.Errorf("brain does not load: %w", err)
+			if rerr := writeFile(path, old); rerr != nil {
+				return fmt.Errorf("%w; restoring %s failed: %v", err, name, rerr)
+			}
+			return err
+		}
+		os.Remove(path)
+		return fmt.Errorf("brain does not load: %w", err)
+	}
+	if p, err := c.r.GetVariable("persona"); err == nil {
+		r.SetVariable("persona", p)
+	}
+	c.r = r
+
+	if !existed {
+		return nil
+	}
+	dir := filepath.Join(c.history, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, time.Now().UTC().Format("20060102-150405.000")), old, 0o644)
+}
+
+// Versions lists the previous versions of a brain file, newest first.
+func (c *Client) Versions(name string) ([]string, error) {
+	if err := brainName(name); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(c.history, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	versions := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			versions = append(versions, e.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+	return versions, nil
+}
+
+// Version returns a previous version of a brain file.
+func (c *Client) Version(name, version string) (string, error) {
+	if err := brainName(name); err != nil {
+		return "", err
+	}
+	if version != filepath.Base(version) || strings.HasPrefix(version, ".") {
+		return "", fmt.Errorf("invalid version %q", version)
+	}
+	b, err := os.ReadFile(filepath.Join(c.history, name, version))
+	return string(b), err
+}
+
+// brainName checks that name is a file directly inside the brain directory.
+func brainName(name string) error {
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".rive" {
+		return fmt.Errorf("invalid brain file name %q", name)
+	}
+	return nil
+}
+
+// writeFile replaces a file atomically.
+func writeFile(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".edit-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -51,8 +51,8 @@ type Client struct {
 	lock sync.Mutex
 }
 
-func New() *Client {
-	db, err := sql.Open("sqlite", "geo.db")
+func New(filename string) *Client {
+	db, err := sql.Open("sqlite", filename)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -6,8 +6,10 @@ import (
 	geohelpers "dmpsupport/rive/geoapi/helpers"
 	"dmpsupport/rive/sessions"
 	"fmt"
+	"io"
 	"log"
 	"math"
+	"path/filepath"
 	"strconv"
 
 	"regexp"
@@ -16,18 +18,23 @@ import (
 	"time"
 
 	"github.com/aichaos/rivescript-go"
+	rssessions "github.com/aichaos/rivescript-go/sessions"
+	"github.com/aichaos/rivescript-go/sessions/memory"
 	// "github.com/aichaos/rivescript-go/lang/javascript"
 	"dmpsupport/rive/handlers/javascript"
 )
 
 type Client struct {
 	r       *rivescript.RiveScript
-	session *sessions.MemoryStore
+	session rssessions.SessionManager
 
 	db *sql.DB
 
-	debug bool
-	geo   *geoapi.Client
+	debug   bool
+	brain   string
+	history string // previous versions of edited brain files
+	seed    int64  // fixed random seed, 0 for a random one
+	geo     *geoapi.Client
 
 	lock sync.Mutex
 }
@@ -39,11 +46,49 @@ type Message struct {
 
 var spaces *regexp.Regexp = regexp.MustCompile(`\s{1,}`)
 
-func New(debug bool) *Client {
-	var session *sessions.MemoryStore = sessions.New("session.db")
-	geo := geoapi.New()
+// New loads the brain and opens the session, learned and geo databases in
+// datadir.
+func New(debug bool, datadir string) *Client {
+	c := &Client{
+		session: sessions.New(filepath.Join(datadir, "session.db")),
+		db:      learned(filepath.Join(datadir, "rivescript.db")),
+		debug:   debug,
+		brain:   "brain",
+		history: filepath.Join(datadir, "brain-history"),
+		geo:     geoapi.New(filepath.Join(datadir, "geo.db")),
+	}
+	r, err := c.load()
+	if err != nil {
+		return nil
+	}
+	c.r = r
+	return c
+}
 
-	db, err := sql.Open("sqlite", "rivescript.db")
+// NewScratch loads the brain of a directory with the learned triggers of
+// datadir, but keeps sessions in memory so replies never change the state of
+// the production bot. A non-zero seed makes random replies reproducible.
+func NewScratch(brain, datadir string, seed int64) (*Client, error) {
+	c := &Client{
+		session: memory.New(),
+		db:      learned(filepath.Join(datadir, "rivescript.db")),
+		brain:   brain,
+		history: filepath.Join(datadir, "brain-history"),
+		seed:    seed,
+		geo:     geoapi.New(filepath.Join(datadir, "geo.db")),
+	}
+	r, err := c.load()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.r = r
+	return c, nil
+}
+
+// learned opens the database of the triggers learned at runtime.
+func learned(filename string) *sql.DB {
+	db, err := sql.Open("sqlite", filename)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -58,25 +103,35 @@ func New(debug bool) *Client {
 	if err != nil {
 		log.Fatal(err)
 	}
+	return db
+}
 
+// load builds a fresh RiveScript interpreter from the brain directory and the
+// learned table.
+func (c *Client) load() (*rivescript.RiveScript, error) {
+	seed := c.seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
 	r := rivescript.New(&rivescript.Config{
-		Debug:          debug,                 // Debug mode, off by default
-		Strict:         true,                  // Strict syntax checking
-		UTF8:           true,                  // UTF-8 support enabled by default
-		Depth:          50,                    // Becomes default 50 if Depth is <= 0
-		Seed:           time.Now().UnixNano(), // Random number seed (default is == 0)
-		SessionManager: session,               // Default in-memory session manager
+		Debug:          c.debug,   // Debug mode, off by default
+		Strict:         true,      // Strict syntax checking
+		UTF8:           true,      // UTF-8 support enabled by default
+		Depth:          50,        // Becomes default 50 if Depth is <= 0
+		Seed:           seed,      // Random number seed (default is == 0)
+		SessionManager: c.session, // Default in-memory session manager
 	})
 	r.SetUnicodePunctuation(`[.,!?;:"@]`)
 	r.SetHandler("javascript", javascript.New(r))
-	if err := r.LoadDirectory("brain"); err != nil {
-		return nil
+	if err := r.LoadDirectory(c.brain); err != nil {
+		return nil, err
 	}
+	db, geo := c.db, c.geo
 
 	var l []string = make([]string, 0)
 	rows, err := db.Query(`SELECT DISTINCT trigger, reply FROM learned;`)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer rows.Close()
 	var trigger, reply string
@@ -87,10 +142,10 @@ func New(debug bool) *Client {
 	}
 	err = r.Stream(strings.Join(l, "\n"))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	if err := r.SortReplies(); err != nil {
-		return nil
+		return nil, err
 	}
 
 	// Subroutines
@@ -152,18 +207,16 @@ func New(debug bool) *Client {
 		})
 	}
 
-	return &Client{
-		r:       r,
-		session: session,
-		db:      db,
-		debug:   debug,
-		geo:     geo,
-	}
+	return r, nil
 }
 
 func (c *Client) Close() error {
 	c.geo.Close()
-	return c.session.Close()
+	c.db.Close()
+	if s, ok := c.session.(io.Closer); ok {
+		return s.Close()
+	}
+	return nil
 }
 
 func (c *Client) GetUnicodePunctuation() *regexp.Regexp {
@@ -174,7 +227,8 @@ func (c *Client) LearnNew(trigger string, reply string) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	trigger = strings.TrimSpace(spaces.ReplaceAllString(c.r.UnicodePunctuation.ReplaceAllString(strings.ToLower(trigger), ""), " "))
+	trigger = c.normalize(trigger)
+	reply = escapeReply(reply)
 
 	stmt, err := c.db.Prepare(`INSERT INTO learned (trigger, reply)VALUES(?,?);`)
 	if err != nil {
@@ -193,9 +247,177 @@ func (c *Client) LearnNew(trigger string, reply string) error {
 	return c.r.SortReplies()
 }
 
+// normalize turns a message into a trigger the way LearnNew stores it.
+func (c *Client) normalize(trigger string) string {
+	return strings.TrimSpace(spaces.ReplaceAllString(c.r.UnicodePunctuation.ReplaceAllString(strings.ToLower(trigger), ""), " "))
+}
+
+// escapeReply turns line breaks in a reply into the \n escape, so a
+// multiline reply can't add commands to the brain.
+func escapeReply(reply string) string {
+	reply = strings.ReplaceAll(reply, "\r\n", "\n")
+	reply = strings.ReplaceAll(reply, "\r", "\n")
+	return strings.ReplaceAll(strings.TrimSpace(reply), "\n", `\n`)
+}
+
+// Learned is a trigger learned at runtime.
+type Learned struct {
+	ID      int64
+	Trigger string
+	Reply   string
+}
+
+// Learned lists the learned triggers whose trigger or reply contains search,
+// newest first.
+func (c *Client) Learned(search string, limit, offset int) ([]Learned, error) {
+	rows, err := c.db.Query(`SELECT rowid, trigger, reply FROM learned
+	WHERE ? = '' OR trigger LIKE ? OR reply LIKE ? ORDER BY rowid DESC LIMIT ? OFFSET ?;`,
+		search, "%"+search+"%", "%"+search+"%", limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var l []Learned
+	for rows.Next() {
+		var e Learned
+		if err := rows.Scan(&e.ID, &e.Trigger, &e.Reply); err != nil {
+			return nil, err
+		}
+		l = append(l, e)
+	}
+	return l, rows.Err()
+}
+
+// UpdateLearned changes a learned trigger. The change takes effect with the
+// next Reload.
+func (c *Client) UpdateLearned(id int64, trigger, reply string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	trigger = c.normalize(trigger)
+	reply = escapeReply(reply)
+	if trigger == "" || reply == "" {
+		return fmt.Errorf("trigger and reply must not be empty")
+	}
+	_, err := c.db.Exec(`UPDATE learned SET trigger = ?, reply = ? WHERE rowid = ?;`, trigger, reply, id)
+	return err
+}
+
+// DeleteLearned removes a learned trigger. The change takes effect with the
+// next Reload.
+func (c *Client) DeleteLearned(id int64) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, err := c.db.Exec(`DELETE FROM learned WHERE rowid = ?;`, id)
+	return err
+}
+
+// Reload rebuilds the brain from disk and the learned table. The running brain
+// is only replaced if the new one loads and sorts without errors.
+func (c *Client) Reload() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	r, err := c.load()
+	if err != nil {
+		return err
+	}
+	if p, err := c.r.GetVariable("persona"); err == nil {
+		r.SetVariable("persona", p)
+	}
+	c.r = r
+	return nil
+}
+
+// SetPersona changes the bot persona used by the `<bot persona>` conditions.
+func (c *Client) SetPersona(persona string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.r.SetVariable("persona", persona)
+}
+
+// Persona returns the current bot persona.
+func (c *Client) Persona() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	p, err := c.r.GetVariable("persona")
+	if err != nil {
+		return "default"
+	}
+	return p
+}
+
+// Forget removes all stored variables and history of a user.
+func (c *Client) Forget(username string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.r.ClearUservars(username)
+}
+
+// Uservars returns the variables of a user.
+func (c *Client) Uservars(username string) (map[string]string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	data, err := c.r.GetUservars(username)
+	if err != nil {
+		return nil, err
+	}
+	return data.Variables, nil
+}
+
+// SetUservar sets a variable of a user, creating the user if needed.
+func (c *Client) SetUservar(username, name, value string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.session.Init(username)
+	c.r.SetUservar(username, name, value)
+}
+
+// Sessions returns the session store of the bot, or nil for scratch clients.
+func (c *Client) Sessions() *sessions.MemoryStore {
+	s, _ := c.session.(*sessions.MemoryStore)
+	return s
+}
+
+// Match describes how the brain answered a message.
+type Match struct {
+	Reply   string
+	Trigger string // trigger that matched the message
+	Topic   string // topic of the user after the reply
+	Persona string
+}
+
 func (c *Client) Reply(username, message string) (string, error) {
+	m, err := c.Match(username, message)
+	return m.Reply, err
+}
+
+// Match replies to a message and reports the trigger that matched.
+func (c *Client) Match(username, message string) (Match, error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
+
+	reply, err := c.reply(username, message)
+	if err != nil {
+		return Match{}, err
+	}
+	m := Match{Reply: reply}
+	m.Trigger, _ = c.r.LastMatch(username)
+	m.Topic, _ = c.r.GetUservar(username, "topic")
+	if m.Persona, err = c.r.GetVariable("persona"); err != nil {
+		m.Persona = "default"
+	}
+	return m, nil
+}
+
+func (c *Client) reply(username, message string) (string, error) {
 	msg := strings.TrimSpace(spaces.ReplaceAllString(message, " "))
 
 	var pf string = c.r.UnicodePunctuation.String()
@@ -205,7 +427,7 @@ func (c *Client) Reply(username, message string) (string, error) {
 	if r, err := c.r.Reply(username, msg); err != nil {
 		c.r.SetUnicodePunctuation(pf)
 		if r2, err := c.r.Reply(username, msg); err != nil {
-			log.Println(err,c.r.UnicodePunctuation.ReplaceAllString(msg, ""))
+			log.Println(err, c.r.UnicodePunctuation.ReplaceAllString(msg, ""))
 			return "", err
 		} else if r2 == "" {
 			return "", fmt.Errorf("empty reply")
@@ -6,6 +6,7 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/aichaos/rivescript-go/sessions"
 	_ "modernc.org/sqlite"
@@ -379,3 +380,102 @@ func (s *MemoryStore) Thaw(username string, action sessions.ThawAction) error {
 		return fmt.Errorf("something went wrong")
 	}
 }
+
+// User is a user with a session.
+type User struct {
+	Name      string
+	LastMatch string
+	Variables int
+	LastSeen  time.Time // time of the last message, zero without history
+}
+
+// Users lists the users whose name contains search, most recently active
+// first.
+func (s *MemoryStore) Users(search string, limit, offset int) ([]User, error) {
+	rows, err := s.db.Query(`SELECT users.username, users.last_match,
+		(SELECT COUNT(*) FROM user_variables WHERE user_id = users.id),
+		COALESCE((SELECT MAX(timestamp) FROM history WHERE user_id = users.id), 0) AS last_seen
+	FROM users WHERE users.username LIKE ? ORDER BY last_seen DESC, users.id DESC LIMIT ? OFFSET ?;`,
+		"%"+search+"%", limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var (
+			u        User
+			lastSeen int64
+		)
+		if err := rows.Scan(&u.Name, &u.LastMatch, &u.Variables, &lastSeen); err != nil {
+			return nil, err
+		}
+		if lastSeen != 0 {
+			u.LastSeen = time.Unix(lastSeen, 0)
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// Variables returns the variables of a user.
+func (s *MemoryStore) Variables(username string) (map[string]string, error) {
+	rows, err := s.db.Query(`SELECT key, value FROM v_user_variables WHERE username = ?;`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vars := make(map[string]string)
+	for rows.Next() {
+		var (
+			key   string
+			value sql.NullString
+		)
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		vars[key] = value.String
+	}
+	return vars, rows.Err()
+}
+
+// Unset removes a variable of a user.
+func (s *MemoryStore) Unset(username, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err := s.db.Exec(`DELETE FROM user_variables WHERE user_id = (SELECT id FROM users WHERE username = ?) AND key = ?;`, username, key)
+	return err
+}
+
+// Exchange is a message of a user and the reply of the bot.
+type Exchange struct {
+	Input     string
+	Reply     string
+	Timestamp time.Time
+}
+
+// History returns the conversation of a user, newest first.
+func (s *MemoryStore) History(username string, limit, offset int) ([]Exchange, error) {
+	rows, err := s.db.Query(`SELECT input, reply, timestamp FROM v_history WHERE username = ? LIMIT ? OFFSET ?;`, username, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []Exchange
+	for rows.Next() {
+		var (
+			e         Exchange
+			timestamp int64
+		)
+		if err := rows.Scan(&e.Input, &e.Reply, &timestamp); err != nil {
+			return nil, err
+		}
+		e.Timestamp = time.Unix(timestamp, 0)
+		history = append(history, e)
+	}
+	return history, rows.Err()
+}
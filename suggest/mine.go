@@ -0,0 +1,216 @@
+// Package suggest mines the message archive for questions that humans
+// answered, so they can be turned into new triggers.
+package suggest
+
+import (
+	"dmpsupport/archive"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Pair is a question and the human reply that answered it.
+type Pair struct {
+	Question archive.Message
+	Answer   archive.Message
+}
+
+// Candidate is a suggested trigger built from a cluster of similar questions.
+type Candidate struct {
+	Trigger  string
+	Reply    string
+	Count    int      // number of questions in the cluster
+	Examples []string // some of the original questions
+}
+
+var questionWords = map[string]bool{
+	"how": true, "what": true, "why": true, "where": true, "when": true, "which": true, "who": true,
+	"can": true, "could": true, "does": true, "do": true, "is": true, "are": true, "should": true,
+	"anyone": true, "help": true,
+}
+
+var stopwords = map[string]bool{
+	"a": true, "an": true, "the": true, "i": true, "me": true, "my": true, "you": true, "your": true,
+	"it": true, "is": true, "are": true, "was": true, "be": true, "to": true, "of": true, "and": true,
+	"or": true, "in": true, "on": true, "for": true, "with": true, "this": true, "that": true,
+	"do": true, "does": true, "did": true, "can": true, "could": true, "hi": true, "hello": true,
+	"hey": true, "so": true, "just": true, "there": true, "any": true, "anyone": true, "im": true,
+	"have": true, "has": true, "but": true, "if": true, "at": true, "we": true, "please": true,
+}
+
+var mentions = regexp.MustCompile(`<[@#][!&]?\d+>`)
+
+// Pairs finds questions and their answers in the archive. An answer is either
+// a reply referencing the question, or the first message of another human in
+// the same channel within window after the question.
+func Pairs(a *archive.Client, window time.Duration) ([]Pair, error) {
+	var (
+		pairs    []Pair
+		channel  string
+		seen     map[string]archive.Message
+		answered map[string]bool
+		open     []archive.Message
+	)
+	err := a.Each(func(m archive.Message) error {
+		if m.Channel != channel {
+			channel = m.Channel
+			seen = make(map[string]archive.Message)
+			answered = make(map[string]bool)
+			open = nil
+		}
+		seen[m.ID] = m
+		if m.Bot || strings.TrimSpace(m.Content) == "" {
+			return nil
+		}
+
+		if m.Reference != "" {
+			q, ok := seen[m.Reference]
+			if ok && !q.Bot && !sameAuthor(q, m) && !answered[q.ID] && isQuestion(q.Content) && isAnswer(m.Content) {
+				pairs = append(pairs, Pair{Question: q, Answer: m})
+				answered[q.ID] = true
+			}
+		} else if !isQuestion(m.Content) && isAnswer(m.Content) {
+			for i := len(open) - 1; i >= 0; i-- {
+				q := open[i]
+				if m.Timestamp.Sub(q.Timestamp) > window {
+					break
+				}
+				if !answered[q.ID] && !sameAuthor(q, m) {
+					pairs = append(pairs, Pair{Question: q, Answer: m})
+					answered[q.ID] = true
+					break
+				}
+			}
+		}
+
+		if isQuestion(m.Content) {
+			open = append(open, m)
+		}
+		// questions older than window can no longer be answered by proximity
+		for len(open) > 0 && m.Timestamp.Sub(open[0].Timestamp) > window {
+			open = open[1:]
+		}
+		return nil
+	})
+	return pairs, err
+}
+
+// Cluster groups pairs with similar questions. Clusters with fewer than
+// minSize questions are dropped. Two questions are similar if the Jaccard
+// index of their words is at least threshold.
+func Cluster(pairs []Pair, threshold float64, minSize int) []Candidate {
+	type cluster struct {
+		words map[string]bool
+		pairs []Pair
+	}
+	var clusters []*cluster
+	for _, p := range pairs {
+		words := Words(p.Question.Content)
+		if len(words) == 0 {
+			continue
+		}
+		var best *cluster
+		var bestScore float64
+		for _, c := range clusters {
+			if s := jaccard(words, c.words); s >= threshold && s > bestScore {
+				best, bestScore = c, s
+			}
+		}
+		if best == nil {
+			best = &cluster{words: words}
+			clusters = append(clusters, best)
+		}
+		best.pairs = append(best.pairs, p)
+	}
+
+	var candidates []Candidate = make([]Candidate, 0)
+	for _, c := range clusters {
+		if len(c.pairs) < minSize {
+			continue
+		}
+		candidates = append(candidates, candidate(c.pairs))
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Count > candidates[j].Count
+	})
+	return candidates
+}
+
+// candidate picks the shortest question as trigger and the most given answer
+// as reply of a cluster.
+func candidate(pairs []Pair) Candidate {
+	c := Candidate{Count: len(pairs)}
+	answers := make(map[string]int)
+	for _, p := range pairs {
+		q := Normalize(p.Question.Content)
+		if c.Trigger == "" || len(q) < len(c.Trigger) {
+			c.Trigger = q
+		}
+		answers[strings.TrimSpace(p.Answer.Content)]++
+		if len(c.Examples) < 5 {
+			c.Examples = append(c.Examples, strings.TrimSpace(p.Question.Content))
+		}
+	}
+	for a, n := range answers {
+		if n > answers[c.Reply] || (n == answers[c.Reply] && len(a) > len(c.Reply)) {
+			c.Reply = a
+		}
+	}
+	return c
+}
+
+// Normalize lowercases text and strips mentions and punctuation, the way
+// triggers are written.
+func Normalize(text string) string {
+	text = mentions.ReplaceAllString(strings.ToLower(text), " ")
+	return strings.Join(strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	}), " ")
+}
+
+// Words returns the significant words of a text.
+func Words(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range strings.Fields(strings.ReplaceAll(Normalize(text), "'", "")) {
+		if len(w) > 1 && !stopwords[w] {
+			words[w] = true
+		}
+	}
+	return words
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	var both int
+	for w := range a {
+		if b[w] {
+			both++
+		}
+	}
+	all := len(a) + len(b) - both
+	if all == 0 {
+		return 0
+	}
+	return float64(both) / float64(all)
+}
+
+func isQuestion(text string) bool {
+	if strings.Contains(text, "?") {
+		return true
+	}
+	words := strings.Fields(Normalize(text))
+	return len(words) >= 3 && questionWords[words[0]]
+}
+
+// isAnswer filters out short acknowledgements like "thanks" or "ok".
+func isAnswer(text string) bool {
+	return len(strings.Fields(text)) >= 3
+}
+
+func sameAuthor(a, b archive.Message) bool {
+	if a.AuthorID != "" && b.AuthorID != "" {
+		return a.AuthorID == b.AuthorID
+	}
+	return a.Author == b.Author
+}
@@ -0,0 +1,138 @@
+package suggest
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+type Status string
+
+const (
+	StatusPending  Status = "pending"
+	StatusAccepted Status = "accepted"
+	StatusRejected Status = "rejected"
+)
+
+// Suggestion is a stored candidate awaiting review.
+type Suggestion struct {
+	ID        int64
+	Trigger   string
+	Reply     string
+	Count     int
+	Examples  []string
+	Status    Status
+	HandledBy string
+	Updated   time.Time
+}
+
+type Store struct {
+	db   *sql.DB
+	lock sync.Mutex
+}
+
+func New(filename string) *Store {
+	db, err := sql.Open("sqlite", filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(`
+	PRAGMA journal_mode = 'WAL';
+	BEGIN TRANSACTION;
+	CREATE TABLE IF NOT EXISTS "suggestions" (
+		"id"	INTEGER,
+		"trigger"	TEXT NOT NULL UNIQUE,
+		"reply"	TEXT NOT NULL,
+		"count"	INTEGER NOT NULL,
+		"examples"	TEXT NOT NULL,
+		"status"	TEXT NOT NULL DEFAULT 'pending',
+		"handled_by"	TEXT NOT NULL DEFAULT '',
+		"updated"	INTEGER NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER)),
+		PRIMARY KEY("id" AUTOINCREMENT)
+	);
+	COMMIT;`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &Store{
+		db: db,
+	}
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Save stores mined candidates. Candidates that were already reviewed keep
+// their status, pending ones are refreshed.
+func (s *Store) Save(candidates []Candidate) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO suggestions (trigger, reply, count, examples)VALUES(?,?,?,?)
+	ON CONFLICT(trigger) DO UPDATE SET
+		reply = CASE WHEN status = 'pending' THEN excluded.reply ELSE reply END,
+		count = excluded.count,
+		examples = excluded.examples;`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, c := range candidates {
+		if c.Trigger == "" || c.Reply == "" {
+			continue
+		}
+		if _, err := stmt.Exec(c.Trigger, c.Reply, c.Count, strings.Join(c.Examples, "\n")); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// List returns suggestions with a status, most asked first.
+func (s *Store) List(status Status, limit, offset int) ([]Suggestion, error) {
+	rows, err := s.db.Query(`SELECT id, trigger, reply, count, examples, status, handled_by, updated FROM suggestions WHERE status = ? ORDER BY count DESC, id LIMIT ? OFFSET ?;`, string(status), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Suggestion = make([]Suggestion, 0)
+	for rows.Next() {
+		var (
+			sg       Suggestion
+			examples string
+			st       string
+			updated  int64
+		)
+		if err := rows.Scan(&sg.ID, &sg.Trigger, &sg.Reply, &sg.Count, &examples, &st, &sg.HandledBy, &updated); err != nil {
+			return nil, err
+		}
+		sg.Examples = strings.Split(examples, "\n")
+		sg.Status = Status(st)
+		sg.Updated = time.Unix(updated, 0)
+		list = append(list, sg)
+	}
+	return list, rows.Err()
+}
+
+// Resolve stores the final trigger and reply of a suggestion and marks it as
+// accepted or rejected.
+func (s *Store) Resolve(id int64, trigger, reply string, status Status, by string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, err := s.db.Exec(`UPDATE suggestions SET trigger = ?, reply = ?, status = ?, handled_by = ?, updated = ? WHERE id = ?;`,
+		trigger, reply, string(status), by, time.Now().UTC().Unix(), id,
+	)
+	return err
+}
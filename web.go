@@ -0,0 +1,640 @@
+package main
+
+import (
+	"dmpsupport/archive"
+	"dmpsupport/ledger"
+	"dmpsupport/queue"
+	"dmpsupport/rive"
+	"dmpsupport/rive/sessions"
+	"dmpsupport/suggest"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// claimTTL is how long a moderator keeps a question to themselves after
+// claiming it.
+const claimTTL = 5 * time.Minute
+
+func serveWeb(dg *discordgo.Session, escalator *Escalator) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			render(w, r, "index.html", struct {
+				Questions []queue.Item
+				Empty     queue.Item // blank question the page fills with queue events
+			}{Questions: questions.Snapshot()})
+		default:
+			http.Error(
+				w,
+				http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed,
+			)
+		}
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		streamQueue(w, r)
+	})
+	mux.HandleFunc("/post", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			r.ParseForm()
+			moderator := sessionOf(r).User
+			if r.FormValue("id") != "" {
+				switch item, err := questions.Claim(r.FormValue("id"), moderator, claimTTL); err {
+				case queue.ErrClaimed:
+					http.Error(w, "This question is claimed by "+item.ClaimedBy+", reload the page.", http.StatusConflict)
+					return
+				case queue.ErrNotQueued:
+					http.Error(w, "This question was already handled, reload the page.", http.StatusConflict)
+					return
+				}
+			}
+			if r.FormValue("action") == "dismiss" && r.FormValue("id") != "" {
+				resolve(r.FormValue("id"), queue.StatusDismissed, moderator)
+				escalator.Cancel(r.FormValue("id"))
+			} else if r.FormValue("id") != "" && r.FormValue("guild") != "" && r.FormValue("channel") != "" && r.FormValue("content") != "" && r.FormValue("trigger") != "" {
+				status := queue.StatusAnswered
+				if r.FormValue("save") == "save" {
+					log.Println("learn new", moderator, r.FormValue("trigger"), r.FormValue("content"))
+					if err := rs.LearnNew(r.FormValue("trigger"), r.FormValue("content")); err != nil {
+						log.Println("[ERR]", err)
+					} else {
+						status = queue.StatusLearned
+					}
+				}
+				resolve(r.FormValue("id"), status, moderator)
+				escalator.Cancel(r.FormValue("id"))
+				entry := ledger.Entry{
+					Source:    r.FormValue("id"),
+					Guild:     r.FormValue("guild"),
+					Channel:   r.FormValue("channel"),
+					Text:      r.FormValue("content"),
+					Persona:   rs.Persona(),
+					Status:    ledger.StatusMuted,
+					Moderator: moderator,
+				}
+				if t, err := discordgo.SnowflakeTimestamp(entry.Source); err == nil {
+					entry.Latency = time.Since(t)
+				}
+				if muted(dg, entry.Guild, entry.Channel) {
+					if err := replyLedger.Record(entry); err != nil {
+						log.Println("[ERR]", err)
+					}
+				} else {
+					go func() {
+						err := dg.ChannelTyping(entry.Channel)
+						if err != nil {
+							log.Printf("Couldn't start typing: %v", err)
+						}
+
+						sent, err := dg.ChannelMessageSendReply(entry.Channel, entry.Text, &discordgo.MessageReference{
+							MessageID: entry.Source,
+							GuildID:   entry.Guild,
+							ChannelID: entry.Channel,
+						})
+						if err != nil {
+							log.Println("[ERR]", err)
+							return
+						}
+						entry.Reply = sent.ID
+						entry.Status = ledger.StatusSent
+						if err := replyLedger.Record(entry); err != nil {
+							log.Println("[ERR]", err)
+						}
+					}()
+				}
+			}
+			http.Redirect(w, r, "/", http.StatusFound)
+		default:
+			http.Error(
+				w,
+				http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed,
+			)
+		}
+	})
+	mux.HandleFunc("/claim", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			moderator := sessionOf(r).User
+			id := r.PostFormValue("id")
+			var err error
+			if r.PostFormValue("action") == "release" {
+				err = questions.Release(id, moderator)
+			} else {
+				var item queue.Item
+				if item, err = questions.Claim(id, moderator, claimTTL); err == queue.ErrClaimed {
+					err = errors.New("claimed by " + item.ClaimedBy)
+				}
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			http.Redirect(w, r, "/", http.StatusFound)
+		default:
+			http.Error(
+				w,
+				http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed,
+			)
+		}
+	})
+	mux.HandleFunc("/ledger", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			if page < 0 {
+				page = 0
+			}
+			f := ledger.Filter{
+				Guild:   r.URL.Query().Get("guild"),
+				Channel: r.URL.Query().Get("channel"),
+				Status:  ledger.Status(r.URL.Query().Get("status")),
+				Search:  r.URL.Query().Get("q"),
+				Limit:   100,
+				Offset:  page * 100,
+			}
+			entries, err := replyLedger.List(f)
+			if err != nil {
+				log.Println("[ERR]", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			render(w, r, "ledger.html", struct {
+				Filter  ledger.Filter
+				Entries []ledger.Entry
+				Page    int
+			}{f, entries, page})
+		default:
+			http.Error(
+				w,
+				http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed,
+			)
+		}
+	})
+	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			if page < 0 {
+				page = 0
+			}
+			q := archive.Query{
+				Text:    r.URL.Query().Get("q"),
+				Author:  r.URL.Query().Get("author"),
+				Channel: r.URL.Query().Get("channel"),
+				Limit:   50,
+				Offset:  page * 50,
+			}
+			var errs []string
+			if v := r.URL.Query().Get("from"); v != "" {
+				t, err := time.Parse("2006-01-02", v)
+				if err != nil {
+					errs = append(errs, "invalid from date")
+				}
+				q.From = t
+			}
+			if v := r.URL.Query().Get("to"); v != "" {
+				t, err := time.Parse("2006-01-02", v)
+				if err != nil {
+					errs = append(errs, "invalid to date")
+				}
+				// the to date is inclusive
+				q.To = t.AddDate(0, 0, 1)
+			}
+			var results []archive.Result
+			if len(errs) == 0 && q.Text != "" {
+				var err error
+				results, err = messageArchive.Search(q)
+				if err != nil {
+					log.Println("[ERR]", err)
+					errs = append(errs, "search failed")
+				}
+			}
+			render(w, r, "search.html", struct {
+				Query   url.Values
+				Results []archive.Result
+				Errors  []string
+				Page    int
+			}{r.URL.Query(), results, errs, page})
+		default:
+			http.Error(
+				w,
+				http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed,
+			)
+		}
+	})
+	mux.HandleFunc("/suggestions", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			if page < 0 {
+				page = 0
+			}
+			list, err := suggestions.List(suggest.StatusPending, 50, page*50)
+			if err != nil {
+				log.Println("[ERR]", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			render(w, r, "suggestions.html", struct {
+				Suggestions []suggest.Suggestion
+				Page        int
+			}{list, page})
+		case http.MethodPost:
+			r.ParseForm()
+			id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+			if err != nil || r.FormValue("trigger") == "" || r.FormValue("reply") == "" {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			status := suggest.StatusRejected
+			if r.FormValue("action") == "accept" {
+				status = suggest.StatusAccepted
+				log.Println("learn new", sessionOf(r).User, r.FormValue("trigger"), r.FormValue("reply"))
+				if err := rs.LearnNew(r.FormValue("trigger"), r.FormValue("reply")); err != nil {
+					log.Println("[ERR]", err)
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			if err := suggestions.Resolve(id, r.FormValue("trigger"), r.FormValue("reply"), status, sessionOf(r).User); err != nil {
+				log.Println("[ERR]", err)
+			}
+			http.Redirect(w, r, "/suggestions", http.StatusFound)
+		default:
+			http.Error(
+				w,
+				http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed,
+			)
+		}
+	})
+	mux.HandleFunc("/learned", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			if page < 0 {
+				page = 0
+			}
+			list, err := rs.Learned(r.URL.Query().Get("q"), 50, page*50)
+			if err != nil {
+				log.Println("[ERR]", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			render(w, r, "learned.html", struct {
+				Search  string
+				Learned []rive.Learned
+				Page    int
+				Message string
+			}{r.URL.Query().Get("q"), list, page, r.URL.Query().Get("msg")})
+		case http.MethodPost:
+			r.ParseForm()
+			moderator := sessionOf(r).User
+			back := url.Values{"q": {r.FormValue("q")}, "page": {r.FormValue("page")}}
+			id, _ := strconv.ParseInt(r.FormValue("id"), 10, 64)
+			var err error
+			switch r.FormValue("action") {
+			case "save":
+				log.Println("edit learned", moderator, id, r.FormValue("trigger"), r.FormValue("reply"))
+				err = rs.UpdateLearned(id, r.FormValue("trigger"), r.FormValue("reply"))
+			case "delete":
+				log.Println("delete learned", moderator, id)
+				err = rs.DeleteLearned(id)
+			case "rebuild":
+				log.Println("rebuild brain", moderator)
+				if err = rs.Reload(); err == nil {
+					back.Set("msg", "Brain rebuilt.")
+				}
+			}
+			if err != nil {
+				log.Println("[ERR]", err)
+				back.Set("msg", err.Error())
+			}
+			http.Redirect(w, r, "/learned?"+back.Encode(), http.StatusFound)
+		default:
+			http.Error(
+				w,
+				http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed,
+			)
+		}
+	})
+	mux.HandleFunc("/brain", func(w http.ResponseWriter, r *http.Request) {
+		data := struct {
+			Files    []string
+			File     string
+			Source   string
+			Versions []string
+			Version  string
+			Errors   rive.SyntaxErrors
+			Message  string
+		}{File: r.FormValue("file"), Version: r.FormValue("version"), Message: r.FormValue("msg")}
+
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			switch {
+			case data.File == "":
+			case data.Version != "":
+				data.Source, err = rs.Version(data.File, data.Version)
+			default:
+				data.Source, err = rs.BrainFile(data.File)
+			}
+		case http.MethodPost:
+			data.Source = r.PostFormValue("source")
+			log.Println("edit brain", sessionOf(r).User, data.File)
+			err = rs.SaveBrainFile(data.File, data.Source)
+			if err == nil {
+				http.Redirect(w, r, "/brain?"+url.Values{"file": {data.File}, "msg": {"Saved, the brain was reloaded."}}.Encode(), http.StatusFound)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			http.Error(
+				w,
+				http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed,
+			)
+			return
+		}
+		if err != nil {
+			if !errors.As(err, &data.Errors) {
+				data.Message = err.Error()
+			}
+		}
+
+		if data.Files, err = rs.BrainFiles(); err != nil {
+			log.Println("[ERR]", err)
+		}
+		if data.File != "" {
+			if data.Versions, err = rs.Versions(data.File); err != nil {
+				log.Println("[ERR]", err)
+			}
+		}
+		render(w, r, "brain.html", data)
+	})
+	mux.HandleFunc("/console", func(w http.ResponseWriter, r *http.Request) {
+		data := struct {
+			User    string
+			Message string
+			Scratch bool
+			Match   rive.Match
+			Vars    map[string]string
+			Error   string
+		}{User: "console", Scratch: true}
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			data.User = strings.TrimSpace(r.PostFormValue("user"))
+			data.Message = r.PostFormValue("message")
+			data.Scratch = r.PostFormValue("scratch") == "scratch"
+			if data.User == "" {
+				data.User = "console"
+			}
+			brain := rs
+			if data.Scratch {
+				var err error
+				if brain, err = scratchBrain(r.PostFormValue("action") == "reset"); err != nil {
+					log.Println("[ERR]", err)
+					data.Error = err.Error()
+					break
+				}
+			}
+			if r.PostFormValue("action") == "send" && strings.TrimSpace(data.Message) != "" {
+				var err error
+				if data.Match, err = brain.Match(data.User, data.Message); err != nil {
+					data.Error = err.Error()
+				}
+			}
+			data.Vars, _ = brain.Uservars(data.User)
+		default:
+			http.Error(
+				w,
+				http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed,
+			)
+			return
+		}
+		render(w, r, "console.html", data)
+	})
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			if page < 0 {
+				page = 0
+			}
+			users, err := rs.Sessions().Users(r.URL.Query().Get("q"), 50, page*50)
+			if err != nil {
+				log.Println("[ERR]", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			render(w, r, "users.html", struct {
+				Search string
+				Users  []sessions.User
+				Page   int
+			}{r.URL.Query().Get("q"), users, page})
+		default:
+			http.Error(
+				w,
+				http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed,
+			)
+		}
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		name := r.FormValue("name")
+		if name == "" {
+			http.Redirect(w, r, "/users", http.StatusFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			if page < 0 {
+				page = 0
+			}
+			vars, err := rs.Sessions().Variables(name)
+			if err != nil {
+				log.Println("[ERR]", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			history, err := rs.Sessions().History(name, 50, page*50)
+			if err != nil {
+				log.Println("[ERR]", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			render(w, r, "user.html", struct {
+				Name      string
+				Variables map[string]string
+				History   []sessions.Exchange
+				Page      int
+			}{name, vars, history, page})
+		case http.MethodPost:
+			moderator := sessionOf(r).User
+			key := strings.TrimSpace(r.PostFormValue("key"))
+			switch r.PostFormValue("action") {
+			case "set":
+				if key != "" {
+					log.Println("set uservar", moderator, name, key, r.PostFormValue("value"))
+					rs.SetUservar(name, key, r.PostFormValue("value"))
+				}
+			case "unset":
+				log.Println("unset uservar", moderator, name, key)
+				if err := rs.Sessions().Unset(name, key); err != nil {
+					log.Println("[ERR]", err)
+				}
+			case "reset":
+				log.Println("reset user", moderator, name)
+				rs.Forget(name)
+				http.Redirect(w, r, "/users", http.StatusFound)
+				return
+			}
+			http.Redirect(w, r, "/user?"+url.Values{"name": {name}}.Encode(), http.StatusFound)
+		default:
+			http.Error(
+				w,
+				http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed,
+			)
+		}
+	})
+	handleAuth(mux)
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./www/static"))))
+	srv := &http.Server{
+		Handler:           authenticate(mux),
+		ReadTimeout:       time.Second * 15,
+		WriteTimeout:      time.Second * 15,
+		IdleTimeout:       time.Second * 15,
+		ReadHeaderTimeout: time.Second * 15,
+		Addr:              conf().Listen,
+	}
+
+	log.Fatal(srv.ListenAndServe())
+}
+
+// streamQueue sends the queue and its changes as server-sent events: a "sync"
+// event with all questions, then an "add" or "remove" event per change.
+func streamQueue(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// the stream outlives the timeouts of the server
+	err := rc.SetWriteDeadline(time.Time{})
+	if err == nil {
+		err = rc.SetReadDeadline(time.Time{})
+	}
+	if err != nil {
+		log.Println("[ERR]", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	snapshot, events, cancel := questions.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	send := func(event string, data any) bool {
+		b, err := json.Marshal(data)
+		if err != nil {
+			log.Println("[ERR]", err)
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	if !send("sync", snapshot) {
+		return
+	}
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			// a closed channel means we fell behind, the browser reconnects and syncs
+			if !ok || !send(e.Type, e.Item) {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+var scratch struct {
+	lock  sync.Mutex
+	brain *rive.Client
+}
+
+// scratchBrain returns the brain of the test console, which keeps its sessions
+// in memory. Resetting it forgets all sessions and loads the current brain.
+func scratchBrain(reset bool) (*rive.Client, error) {
+	scratch.lock.Lock()
+	defer scratch.lock.Unlock()
+
+	if scratch.brain != nil && !reset {
+		return scratch.brain, nil
+	}
+	c, err := rive.NewScratch("brain", conf().DataDir, 0)
+	if err != nil {
+		return nil, err
+	}
+	if scratch.brain != nil {
+		scratch.brain.Close()
+	}
+	c.SetPersona(rs.Persona())
+	scratch.brain = c
+	return c, nil
+}
+
+// render executes a template of www/templates. Templates are parsed on every
+// request so they can be edited while the bot is running. The csrf and user
+// functions return the session of the request.
+func render(w http.ResponseWriter, r *http.Request, name string, data any) {
+	sess := sessionOf(r)
+	templ, err := template.New("").Funcs(template.FuncMap{
+		"add":         func(a, b int) int { return a + b },
+		"messageLink": messageLink,
+		"csrf":        func() string { return sess.CSRF },
+		"user":        func() string { return sess.User },
+	}).ParseFS(os.DirFS("./www/templates"), "*.html")
+	if err != nil {
+		log.Println("[ERR]", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if err := templ.ExecuteTemplate(w, name, data); err != nil {
+		log.Println("[ERR]", err)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"dmpsupport/auth"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const sessionCookie = "session"
+
+type sessionKey struct{}
+
+// authenticate lets only logged in moderators through and checks the CSRF
+// token of every form they post.
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/login", strings.HasPrefix(r.URL.Path, "/oauth/"), strings.HasPrefix(r.URL.Path, "/static/"):
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var (
+			sess auth.Session
+			err  = auth.ErrNoSession
+		)
+		if c, cerr := r.Cookie(sessionCookie); cerr == nil {
+			sess, err = logins.Session(c.Value)
+		}
+		if err != nil {
+			if err != auth.ErrNoSession {
+				log.Println("[ERR]", err)
+			}
+			if r.Method == http.MethodGet {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPost && !sess.ValidCSRF(r.PostFormValue("csrf")) {
+			http.Error(w, "invalid CSRF token, reload the page and try again", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, sess)))
+	})
+}
+
+// sessionOf returns the session of an authenticated request.
+func sessionOf(r *http.Request) auth.Session {
+	sess, _ := r.Context().Value(sessionKey{}).(auth.Session)
+	return sess
+}
+
+func oauthOf() (auth.OAuth, bool) {
+	o := conf().Web.OAuth
+	return auth.OAuth{
+		ClientID:     o.ClientID,
+		ClientSecret: o.ClientSecret,
+		RedirectURL:  o.RedirectURL,
+		AuthorizeURL: o.AuthorizeURL,
+		TokenURL:     o.TokenURL,
+		UserURL:      o.UserURL,
+	}, o.ClientID != ""
+}
+
+// handleAuth registers the login and logout pages.
+func handleAuth(mux *http.ServeMux) {
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		_, oauth := oauthOf()
+		data := struct {
+			Next  string
+			OAuth bool
+			Error string
+		}{localPath(r.FormValue("next")), oauth, ""}
+
+		switch r.Method {
+		case http.MethodGet:
+			render(w, r, "login.html", data)
+		case http.MethodPost:
+			sess, err := logins.Login(r.PostFormValue("name"), r.PostFormValue("password"))
+			if err != nil {
+				if err != auth.ErrInvalidLogin {
+					log.Println("[ERR]", err)
+				}
+				log.Println("[INFO]", "failed login of", r.PostFormValue("name"), "from", r.RemoteAddr)
+				data.Error = auth.ErrInvalidLogin.Error()
+				w.WriteHeader(http.StatusUnauthorized)
+				render(w, r, "login.html", data)
+				return
+			}
+			setSession(w, r, sess)
+			http.Redirect(w, r, data.Next, http.StatusFound)
+		default:
+			http.Error(
+				w,
+				http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed,
+			)
+		}
+	})
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if err := logins.Logout(sessionOf(r).Token); err != nil {
+			log.Println("[ERR]", err)
+		}
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+		http.Redirect(w, r, "/login", http.StatusFound)
+	})
+	mux.HandleFunc("/oauth/login", func(w http.ResponseWriter, r *http.Request) {
+		o, ok := oauthOf()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		state := auth.Token()
+		http.SetCookie(w, &http.Cookie{
+			Name:     "oauth_state",
+			Value:    state + "|" + localPath(r.FormValue("next")),
+			Path:     "/oauth/",
+			MaxAge:   600,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, o.AuthCodeURL(state), http.StatusFound)
+	})
+	mux.HandleFunc("/oauth/callback", func(w http.ResponseWriter, r *http.Request) {
+		o, ok := oauthOf()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		var state, next string
+		if c, err := r.Cookie("oauth_state"); err == nil {
+			state, next, _ = strings.Cut(c.Value, "|")
+		}
+		if state == "" || r.FormValue("state") != state {
+			http.Error(w, "login expired, please try again", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "oauth_state", Value: "", Path: "/oauth/", MaxAge: -1, HttpOnly: true})
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		user, err := o.Exchange(ctx, r.FormValue("code"))
+		if err != nil {
+			log.Println("[ERR]", err)
+			http.Error(w, "Discord login failed", http.StatusBadGateway)
+			return
+		}
+		if !conf().IsModerator(user.ID) {
+			log.Println("[INFO]", "refused Discord login of", user.Username, user.ID)
+			http.Error(w, "you are not a moderator", http.StatusForbidden)
+			return
+		}
+		sess, err := logins.Start("discord:" + user.Username)
+		if err != nil {
+			log.Println("[ERR]", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		setSession(w, r, sess)
+		http.Redirect(w, r, localPath(next), http.StatusFound)
+	})
+}
+
+func setSession(w http.ResponseWriter, r *http.Request, sess auth.Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    sess.Token,
+		Path:     "/",
+		Expires:  sess.Expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// localPath only allows redirects to pages of the web UI.
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
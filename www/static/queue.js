@@ -0,0 +1,110 @@
+// Keeps the queue page up to date with the events of /events.
+(function () {
+    const queue = document.getElementById("queue");
+    const template = document.getElementById("question");
+
+    function find(id) {
+        for (const card of queue.querySelectorAll(".question")) {
+            if (card.dataset.id === id) {
+                return card;
+            }
+        }
+        return null;
+    }
+
+    function card(item) {
+        const card = template.content.firstElementChild.cloneNode(true);
+        card.dataset.id = item.ID;
+        card.querySelector(".author").textContent = item.Author;
+        card.querySelector("[name=trigger]").value = item.Content;
+        card.querySelector("[name=id]").value = item.ID;
+        card.querySelector("[name=guild]").value = item.Guild;
+        card.querySelector("[name=channel]").value = item.Channel;
+        card.querySelector(".claim-form [name=id]").value = item.ID;
+        setClaim(card, item);
+        return card;
+    }
+
+    // showClaim displays who claimed a question and hides the claim once it
+    // expired.
+    function showClaim(card) {
+        const claim = card.querySelector(".claim");
+        const expires = new Date(claim.dataset.claimExpires);
+        clearTimeout(card.claimTimer);
+        if (!claim.dataset.claimedBy || !(expires > new Date())) {
+            claim.textContent = "";
+            return;
+        }
+        claim.textContent = "claimed by " + claim.dataset.claimedBy + " until " + expires.toLocaleTimeString();
+        card.claimTimer = setTimeout(function () { claim.textContent = ""; }, expires - new Date());
+    }
+
+    function setClaim(card, item) {
+        const claim = card.querySelector(".claim");
+        claim.dataset.claimedBy = item.ClaimedBy || "";
+        claim.dataset.claimExpires = item.ClaimedBy ? item.ClaimExpires : "";
+        showClaim(card);
+    }
+
+    function remove(id) {
+        const old = find(id);
+        if (old) {
+            old.remove();
+        }
+    }
+
+    for (const card of queue.querySelectorAll(".question")) {
+        showClaim(card);
+    }
+
+    // claim without reloading, so replies being typed are kept
+    queue.addEventListener("submit", function (e) {
+        if (!e.target.classList.contains("claim-form")) {
+            return;
+        }
+        e.preventDefault();
+        const data = new FormData(e.target);
+        data.set("action", e.submitter ? e.submitter.value : "claim");
+        fetch("/claim", { method: "POST", body: new URLSearchParams(data) }).then(function (resp) {
+            if (!resp.ok) {
+                resp.text().then(function (text) { alert(text); });
+            }
+        });
+    });
+
+    const events = new EventSource("/events");
+    // sent on every (re)connect, questions being answered are kept as they are
+    events.addEventListener("sync", function (e) {
+        const items = JSON.parse(e.data) || [];
+        const ids = new Set(items.map(function (item) { return item.ID; }));
+        for (const old of queue.querySelectorAll(".question")) {
+            if (!ids.has(old.dataset.id)) {
+                old.remove();
+            }
+        }
+        for (const item of items.slice().reverse()) {
+            if (!find(item.ID)) {
+                queue.prepend(card(item));
+            }
+        }
+    });
+    events.addEventListener("add", function (e) {
+        const item = JSON.parse(e.data);
+        const old = find(item.ID);
+        if (old) {
+            old.replaceWith(card(item));
+        } else {
+            queue.prepend(card(item));
+        }
+    });
+    events.addEventListener("claim", function (e) {
+        const item = JSON.parse(e.data);
+        const card = find(item.ID);
+        if (card) {
+            setClaim(card, item);
+        }
+    });
+    events.addEventListener("remove", function (e) {
+        remove(JSON.parse(e.data).ID);
+    });
+})();